@@ -0,0 +1,12 @@
+package admin
+
+// Admin represents a platform administrator
+type Admin struct {
+	AdminID  int    `json:"admin_id"`
+	Name     string `json:"name"`
+	LastName string `json:"last_name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (admin Admin) client() {}
@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	mec "github.com/CartechAPI/mechanic"
+	us "github.com/CartechAPI/user"
+	"github.com/CartechAPI/utils"
+)
+
+var (
+	// ErrInvalidPagination invalid pagination params
+	ErrInvalidPagination = errors.New("invalid pagination params")
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// Pagination reads the limit and offset query params
+func Pagination(r *http.Request) (int, int, error) {
+	limit := defaultPageSize
+	offset := 0
+
+	var err error
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return 0, 0, ErrInvalidPagination
+		}
+	}
+
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err = strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return 0, 0, ErrInvalidPagination
+		}
+	}
+
+	return limit, offset, nil
+}
+
+// GetAllUsers handles the request for listing the users of the platform
+func GetAllUsers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, err := Pagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		users, err := us.GetAllUsers(db, limit, offset)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		for i := range users {
+			users[i].Password = ""
+		}
+
+		utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"users": users})
+	}
+}
+
+// GetAllMechanics handles the request for listing the mechanics of the platform
+func GetAllMechanics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, err := Pagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		mechanics, err := mec.GetAllMechanics(db, limit, offset)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		for i := range mechanics {
+			mechanics[i].Password = ""
+		}
+
+		utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"mechanics": mechanics})
+	}
+}
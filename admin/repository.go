@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"database/sql"
+	"log"
+)
+
+// GetAdminByEmail returns an admin given its email
+func GetAdminByEmail(db *sql.DB, email string) (*Admin, error) {
+	query := "SELECT admin_id, name, last_name, email, password FROM admin_table WHERE email = $1"
+
+	admin := Admin{}
+	err := db.QueryRow(query, email).Scan(&admin.AdminID, &admin.Name, &admin.LastName, &admin.Email, &admin.Password)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("failed_to_get_admin: " + err.Error())
+		}
+
+		return nil, err
+	}
+
+	return &admin, nil
+}
+
+// GetAdminByID returns an admin given its id
+func GetAdminByID(db *sql.DB, id int) (*Admin, error) {
+	query := "SELECT admin_id, name, last_name, email, password FROM admin_table WHERE admin_id = $1"
+
+	admin := Admin{}
+	err := db.QueryRow(query, id).Scan(&admin.AdminID, &admin.Name, &admin.LastName, &admin.Email, &admin.Password)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("failed_to_get_admin: " + err.Error())
+		}
+
+		return nil, err
+	}
+
+	return &admin, nil
+}
@@ -0,0 +1,104 @@
+package assigner
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/CartechAPI/dispatch"
+	"github.com/CartechAPI/notifications"
+	"github.com/CartechAPI/order"
+	"github.com/CartechAPI/outbox"
+	"github.com/CartechAPI/queue"
+)
+
+// ConsumerName identifies the assigner when deduplicating messages
+const ConsumerName = "assigner"
+
+// ErrConsumerStopped the consumer stopped delivering messages without being asked to, usually because the
+// connection to the broker dropped
+var ErrConsumerStopped = errors.New("consumer stopped unexpectedly")
+
+// Run dispatches the orders delivered by the consumer and keeps offering the pending ones to
+// mechanics until stop is closed, it returns once the message being handled is done. If the consumer
+// stops on its own Run returns with an error, so the process can exit and be restarted
+func Run(db *sql.DB, consumer queue.Consumer, notifier notifications.Notifier, stop <-chan struct{}) error {
+	consumeDone := make(chan struct{})
+	sweepDone := make(chan struct{})
+	go func() {
+		runDispatchSweep(db, notifier, sweepInterval, consumeDone)
+		close(sweepDone)
+	}()
+
+	go func() {
+		select {
+		case <-stop:
+		case <-consumeDone:
+			return
+		}
+
+		log.Println("shutting down the assigner")
+
+		err := consumer.Stop()
+		if err != nil {
+			log.Println("error_stopping_consumer: " + err.Error())
+		}
+	}()
+
+	err := consumer.Consume(func(message queue.Message) error {
+		return handleMessage(db, notifier, message)
+	})
+
+	close(consumeDone)
+	<-sweepDone
+
+	select {
+	case <-stop:
+	default:
+		if err == nil {
+			err = ErrConsumerStopped
+		}
+	}
+
+	return err
+}
+
+// handleMessage assigns the order of the message once, the outbox relay may deliver a message more than once
+func handleMessage(db *sql.DB, notifier notifications.Notifier, message queue.Message) error {
+	log.Println("Received a message:" + string(message.Body))
+
+	if message.ID != "" {
+		processed, err := outbox.IsProcessed(db, ConsumerName, message.ID)
+		if err != nil {
+			return err
+		}
+
+		if processed {
+			log.Println("skipping duplicated message " + message.ID)
+			return nil
+		}
+	}
+
+	err := assignOrder(db, notifier, message.Body)
+	if err != nil {
+		return err
+	}
+
+	if message.ID == "" {
+		return nil
+	}
+
+	return outbox.MarkProcessed(db, ConsumerName, message.ID)
+}
+
+func assignOrder(db *sql.DB, notifier notifications.Notifier, messageBody []byte) error {
+	serviceOrder := order.ServiceOrder{}
+	err := json.Unmarshal(messageBody, &serviceOrder)
+	if err != nil {
+		return queue.Permanent(err)
+	}
+
+	// the first mechanics are offered the order right away, the sweep takes care of the next ones
+	return dispatchOrder(db, notifier, serviceOrder.ServiceOrderID, dispatch.DefaultStages, dispatch.DefaultBatchSize)
+}
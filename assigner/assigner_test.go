@@ -0,0 +1,35 @@
+package assigner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/CartechAPI/queue"
+	"github.com/stretchr/testify/require"
+)
+
+// closedConsumer stops delivering right away, like a consumer whose connection dropped
+type closedConsumer struct {
+	err error
+}
+
+func (consumer closedConsumer) Consume(handler queue.Handler) error {
+	return consumer.err
+}
+
+func (consumer closedConsumer) Stop() error {
+	return nil
+}
+
+func TestRunReturnsWhenTheConsumerStops(t *testing.T) {
+	c := require.New(t)
+
+	c.Equal(ErrConsumerStopped, Run(nil, closedConsumer{}, nil, nil))
+
+	consumeErr := errors.New("channel closed")
+	c.Equal(consumeErr, Run(nil, closedConsumer{err: consumeErr}, nil, make(chan struct{})))
+
+	stop := make(chan struct{})
+	close(stop)
+	c.Nil(Run(nil, closedConsumer{}, nil, stop))
+}
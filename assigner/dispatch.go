@@ -0,0 +1,127 @@
+package assigner
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/CartechAPI/dispatch"
+	"github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/notifications"
+	"github.com/CartechAPI/order"
+	"github.com/CartechAPI/service"
+	"github.com/CartechAPI/shared"
+)
+
+// sweepInterval is how often expired and declined offers are replaced with offers to the next mechanics
+const sweepInterval = 10 * time.Second
+
+// runDispatchSweep keeps offering the pending orders until a mechanic accepts them or the dispatch gives up,
+// it returns once stop is closed
+func runDispatchSweep(db *sql.DB, notifier notifications.Notifier, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := sweepOrders(db, notifier)
+			if err != nil {
+				log.Println("error_sweeping_orders: " + err.Error())
+			}
+		}
+	}
+}
+
+func sweepOrders(db *sql.DB, notifier notifications.Notifier) error {
+	_, err := order.ExpireOffers(db)
+	if err != nil {
+		return err
+	}
+
+	createdAfter := time.Now().Add(-dispatch.Duration(dispatch.DefaultStages))
+	orderIDs, err := order.GetOrdersAwaitingMechanic(db, dispatch.DefaultBatchSize, createdAfter)
+	if err != nil {
+		return err
+	}
+
+	for _, orderID := range orderIDs {
+		err = dispatchOrder(db, notifier, orderID, dispatch.DefaultStages, dispatch.DefaultBatchSize)
+		if err != nil {
+			log.Println("error_dispatching_order " + strconv.Itoa(orderID) + ": " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// dispatchOrder offers the order to the closest mechanics that were not offered it yet, keeping up to
+// batchSize open offers. The search radius widens the longer the order has been waiting
+func dispatchOrder(db *sql.DB, notifier notifications.Notifier, orderID int, stages []dispatch.Stage, batchSize int) error {
+	serviceOrder, err := order.GetServiceOrderByID(db, orderID)
+	if err != nil {
+		return err
+	}
+
+	if serviceOrder.Status != order.ServiceOrderStatusPending || serviceOrder.MechanicID != 0 {
+		return nil
+	}
+
+	radiusKm, ok := dispatch.RadiusAt(stages, time.Since(*serviceOrder.CreatedAt))
+	if !ok {
+		log.Println("no mechanic took the order " + strconv.Itoa(orderID))
+		return nil
+	}
+
+	offers, err := order.GetServiceOrderOffers(db, orderID)
+	if err != nil {
+		return err
+	}
+
+	offered := map[int]bool{}
+	openOffers := 0
+	for _, offer := range offers {
+		offered[offer.MechanicID] = true
+		if order.IsOfferOpen(offer, time.Now()) {
+			openOffers++
+		}
+	}
+
+	if openOffers >= batchSize {
+		return nil
+	}
+
+	locations, err := mechanic.GetAvailableMechanicLocations(db, serviceOrder.ServiceID, mechanic.HeartbeatTimeout)
+	if err != nil {
+		return err
+	}
+
+	svc, err := service.GetServiceByID(db, serviceOrder.ServiceID)
+	if err != nil {
+		return err
+	}
+
+	candidates := dispatch.Rank(serviceOrder.Lat, serviceOrder.Lng, locations)
+	for _, candidate := range dispatch.NextBatch(candidates, radiusKm, offered, batchSize-openOffers) {
+		_, err = order.CreateOffer(db, orderID, candidate.MechanicID, candidate.DistanceKm, dispatch.OfferTimeout)
+		if err != nil {
+			return err
+		}
+
+		params := notifications.Params{
+			"service_name": svc.ServiceName,
+			"distance":     fmt.Sprintf("%.1f", candidate.DistanceKm),
+		}
+
+		err = notifications.NotifyClient(db, notifier, shared.ClientTypeMechanic, candidate.MechanicID, notifications.EventNewOrder, params)
+		if err != nil {
+			log.Println("error_notifying_mechanic: " + err.Error())
+		}
+	}
+
+	return nil
+}
@@ -6,12 +6,16 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"os"
+	"strconv"
 
+	adm "github.com/CartechAPI/admin"
+	"github.com/CartechAPI/jwtkeys"
 	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/sender"
 	"github.com/CartechAPI/shared"
 	us "github.com/CartechAPI/user"
 	"github.com/CartechAPI/utils"
+	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -19,17 +23,11 @@ type apiResponse struct {
 	Message string `json:"message"`
 }
 
-var tokenSigningKey string
-
 var (
 	ErrMissingFields  = errors.New("missing_fields")
 	ErrNotUniqueEmail = errors.New("email must be unique")
 )
 
-func init() {
-	tokenSigningKey = os.Getenv("SECRET")
-}
-
 // Index returns handler of GET / endpoint
 func Index() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -39,7 +37,7 @@ func Index() http.HandlerFunc {
 }
 
 // Login returns handler of POST /login endpoint
-func Login(db *sql.DB) http.HandlerFunc {
+func Login(db *sql.DB, contactSender sender.Sender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := &us.User{}
 
@@ -49,7 +47,7 @@ func Login(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		token, user, err := login(db, user)
+		tokens, user, err := login(db, contactSender, user)
 		if showableErr, ok := err.(shared.ShowableError); ok {
 			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
 			return
@@ -73,7 +71,8 @@ func Login(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		responseMap["token"] = token
+		responseMap["token"] = tokens.Token
+		responseMap["refresh_token"] = tokens.RefreshToken
 
 		utils.RespondJSON(w, http.StatusOK, responseMap)
 		return
@@ -81,7 +80,7 @@ func Login(db *sql.DB) http.HandlerFunc {
 }
 
 // SignUp returns the handler of the POST /signup endpoint
-func SignUp(db *sql.DB) http.HandlerFunc {
+func SignUp(db *sql.DB, contactSender sender.Sender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user := &us.User{}
 
@@ -101,6 +100,8 @@ func SignUp(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		sendSignUpVerificationCodes(db, contactSender, shared.ClientTypeUser, user.UserID)
+
 		user.Password = ""
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(user)
@@ -128,7 +129,7 @@ func validateMechanicSignUpFields(mechanic mec.Mechanic) error {
 }
 
 // MechanicLogin is the login function for the mechanic
-func MechanicLogin(db *sql.DB) http.HandlerFunc {
+func MechanicLogin(db *sql.DB, contactSender sender.Sender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		mechanic := mec.Mechanic{}
 		err := json.NewDecoder(r.Body).Decode(&mechanic)
@@ -137,19 +138,9 @@ func MechanicLogin(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		if mechanic.Email == "" {
-			utils.RespondWithError(w, http.StatusBadRequest, "missing email")
-			return
-		}
-
-		if mechanic.Password == "" {
-			utils.RespondWithError(w, http.StatusBadRequest, "missing password")
-			return
-		}
-
-		retrievedMechanic, err := mec.GetMechanicByEmail(db, mechanic.Email)
-		if err == sql.ErrNoRows {
-			utils.RespondWithError(w, http.StatusBadRequest, "invalid email")
+		tokens, retrievedMechanic, err := mechanicLogin(db, contactSender, mechanic)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
 			return
 		}
 
@@ -158,21 +149,14 @@ func MechanicLogin(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		if !isPasswordCorrect(mechanic.Password, retrievedMechanic.Password) {
-			utils.RespondWithError(w, http.StatusBadRequest, "invalid password")
-			return
-		}
-
-		retrievedMechanic.Password = ""
-
-		token, err := GenerateToken(retrievedMechanic.MechanicID, shared.ClientTypeMechanic)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+		if tokens.MFARequired {
+			utils.RespondJSON(w, http.StatusOK, tokens)
 			return
 		}
 
 		responseMap := map[string]interface{}{}
-		responseMap["token"] = token
+		responseMap["token"] = tokens.Token
+		responseMap["refresh_token"] = tokens.RefreshToken
 		responseMap["mechanic"] = retrievedMechanic
 
 		utils.RespondJSON(w, http.StatusOK, responseMap)
@@ -181,7 +165,7 @@ func MechanicLogin(db *sql.DB) http.HandlerFunc {
 }
 
 // MechanichSignUp the sign up for the mechanic
-func MechanichSignUp(db *sql.DB) http.HandlerFunc {
+func MechanichSignUp(db *sql.DB, contactSender sender.Sender) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		mechanic := mec.Mechanic{}
 
@@ -206,7 +190,7 @@ func MechanichSignUp(db *sql.DB) http.HandlerFunc {
 		}
 
 		mechanic.Password = string(hashedPassword)
-		err = mec.InsertMechanic(db, mechanic)
+		insertedMechanic, err := mec.InsertMechanic(db, &mechanic)
 		if err, ok := err.(shared.PublicError); ok {
 			showableError := err.(shared.ShowableError)
 			utils.RespondWithError(w, showableError.StatusCode, showableError.Message)
@@ -217,31 +201,31 @@ func MechanichSignUp(db *sql.DB) http.HandlerFunc {
 			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
 			return
 		}
+
+		sendSignUpVerificationCodes(db, contactSender, shared.ClientTypeMechanic, insertedMechanic.MechanicID)
 	}
 }
 
 func validateSessionFields(session Session) error {
-	if session.UserID == 0 {
-		return errors.New("missing user id")
-	}
-
 	if session.Token == "" {
 		return errors.New("missing token")
 	}
 
-	if session.UserType == "" {
-		return errors.New("missing user type")
-	}
-
 	return nil
 }
 
-// StoreSession handles the request for storing a session
+// StoreSession handles the request for registering the device of the client for notifications
 func StoreSession(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
 		session := &Session{}
 
-		err := json.NewDecoder(r.Body).Decode(session)
+		err = json.NewDecoder(r.Body).Decode(session)
 		if err != nil {
 			utils.RespondWithError(w, http.StatusBadRequest, "invalid user body")
 			return
@@ -253,6 +237,10 @@ func StoreSession(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// the owner of the device is always the authenticated client
+		session.UserID = id
+		session.UserType = clientType
+
 		session, err = saveSession(db, *session)
 		if err != nil {
 			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
@@ -262,3 +250,455 @@ func StoreSession(db *sql.DB) http.HandlerFunc {
 		utils.RespondJSON(w, http.StatusCreated, session)
 	}
 }
+
+// GetSessions handles the request for listing the devices of the client
+func GetSessions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		sessions, err := GetClientSessions(db, clientType, id)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"sessions": sessions})
+	}
+}
+
+// GetSession handles the request for getting a device of the client
+func GetSession(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		sessionID, err := strconv.Atoi(mux.Vars(r)["session_id"])
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request param")
+			return
+		}
+
+		session, err := getClientSession(db, sessionID, clientType, id)
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(w, http.StatusNotFound, "resource not found")
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, session)
+	}
+}
+
+// DeleteSession handles the request for removing a device of the client
+func DeleteSession(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		sessionID, err := strconv.Atoi(mux.Vars(r)["session_id"])
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request param")
+			return
+		}
+
+		deleted, err := deleteClientSession(db, sessionID, clientType, id)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		if !deleted {
+			utils.RespondWithError(w, http.StatusNotFound, "resource not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// RefreshAccessToken handles the request for exchanging a refresh token for a new pair of tokens
+func RefreshAccessToken(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := struct {
+			RefreshToken string `json:"refresh_token"`
+		}{}
+
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		tokens, err := refreshTokens(db, body.RefreshToken)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, tokens)
+	}
+}
+
+// Logout handles the request for ending the current session
+func Logout(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticate(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		body := struct {
+			RefreshToken string `json:"refresh_token"`
+			DeviceToken  string `json:"device_token"`
+		}{}
+
+		// the body is optional, only the access token is required to logout
+		if r.ContentLength != 0 {
+			err = json.NewDecoder(r.Body).Decode(&body)
+			if err != nil {
+				utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+				return
+			}
+		}
+
+		err = logout(db, *claims, body.RefreshToken, body.DeviceToken)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// LogoutAll handles the request for ending every session of the client
+func LogoutAll(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := authenticate(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		err = logoutAll(db, *claims)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type passwordResetRequest struct {
+	Email    string `json:"email"`
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+// ForgotPassword handles the request for sending a password reset code to a client of the given type
+func ForgotPassword(db *sql.DB, contactSender sender.Sender, clientType shared.ClientType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := passwordResetRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err = forgotPassword(db, contactSender, clientType, body.Email)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusAccepted, apiResponse{"if the email is registered a code was sent to it"})
+	}
+}
+
+// ResetPassword handles the request for setting a new password using a reset code
+func ResetPassword(db *sql.DB, clientType shared.ClientType) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := passwordResetRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err = resetPassword(db, clientType, body.Email, body.Code, body.Password)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, apiResponse{"password updated"})
+	}
+}
+
+type verificationRequest struct {
+	Channel VerificationChannel `json:"channel"`
+	Code    string              `json:"code"`
+}
+
+// Verify handles the request for verifying the email or phone number of the client
+func Verify(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		body := verificationRequest{}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err = verifyContact(db, clientType, id, body.Channel, body.Code)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, apiResponse{"verified"})
+	}
+}
+
+// ResendVerificationCode handles the request for sending a new verification code
+func ResendVerificationCode(db *sql.DB, contactSender sender.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		body := verificationRequest{}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		if !isVerificationChannelValid(body.Channel) {
+			utils.RespondWithError(w, http.StatusBadRequest, ErrInvalidVerificationChannel.Message)
+			return
+		}
+
+		err = sendVerificationCode(db, contactSender, clientType, id, body.Channel)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusAccepted, apiResponse{"code sent"})
+	}
+}
+
+// AdminLogin is the login function for the admins
+func AdminLogin(db *sql.DB, contactSender sender.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		admin := adm.Admin{}
+		err := json.NewDecoder(r.Body).Decode(&admin)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		tokens, retrievedAdmin, err := adminLogin(db, contactSender, admin)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		if tokens.MFARequired {
+			utils.RespondJSON(w, http.StatusOK, tokens)
+			return
+		}
+
+		responseMap := map[string]interface{}{}
+		responseMap["token"] = tokens.Token
+		responseMap["refresh_token"] = tokens.RefreshToken
+		responseMap["admin"] = retrievedAdmin
+
+		utils.RespondJSON(w, http.StatusOK, responseMap)
+	}
+}
+
+// JWKS returns the public keys that can be used to verify the tokens
+func JWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keySet, err := jwtkeys.Default()
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"keys": keySet.PublicKeys()})
+	}
+}
+
+type mfaRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// EnrollMFA handles the request for starting the two factor authentication enrollment
+func EnrollMFA(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		enrollment, err := enrollMFA(db, clientType, id)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, enrollment)
+	}
+}
+
+// ConfirmMFA handles the request for enabling two factor authentication with a first code
+func ConfirmMFA(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		body := mfaRequest{}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		recoveryCodes, err := confirmMFA(db, clientType, id, body.Code)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"recovery_codes": recoveryCodes})
+	}
+}
+
+// DisableMFA handles the request for turning off two factor authentication
+func DisableMFA(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		body := mfaRequest{}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err = disableMFA(db, clientType, id, body.Code, body.RecoveryCode)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// VerifyMFA handles the second step of the login, exchanging the mfa token and a code for the tokens
+func VerifyMFA(db *sql.DB, contactSender sender.Sender) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body := mfaRequest{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		if body.MFAToken == "" {
+			utils.RespondWithError(w, http.StatusBadRequest, "missing mfa token")
+			return
+		}
+
+		tokens, err := verifyMFALogin(db, contactSender, body.MFAToken, body.Code, body.RecoveryCode)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, tokens)
+	}
+}
@@ -1,13 +1,19 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"log"
 	"net/http"
-	"os"
 	"time"
 
+	adm "github.com/CartechAPI/admin"
+	"github.com/CartechAPI/jwtkeys"
 	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/sender"
 	"github.com/CartechAPI/shared"
 	us "github.com/CartechAPI/user"
 	usr "github.com/CartechAPI/user"
@@ -32,39 +38,150 @@ var (
 	ErrMissingPhoneNumber = shared.NewBadRequestError("missing phone number")
 	// ErrInvalidCredentials invalid credentials
 	ErrInvalidCredentials = shared.NewBadRequestError("incorrect email or password")
+	// ErrMissingRefreshToken missing refresh token
+	ErrMissingRefreshToken = shared.NewBadRequestError("missing refresh token")
+	// ErrInvalidRefreshToken invalid refresh token
+	ErrInvalidRefreshToken = shared.NewShowableError("invalid refresh token", http.StatusUnauthorized)
+	// ErrRevokedToken revoked token
+	ErrRevokedToken = shared.NewShowableError("revoked token", http.StatusUnauthorized)
 )
 
-func login(db *sql.DB, user *us.User) (string, *usr.User, error) {
+const (
+	// accessTokenDuration is how long an access token is valid
+	accessTokenDuration = 15 * time.Minute
+	// refreshTokenDuration is how long a refresh token is valid
+	refreshTokenDuration = 30 * 24 * time.Hour
+)
+
+// dummyPasswordHash is compared against when the account does not exist, so both cases take the same time
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("cartech-dummy-password"), 10)
+
+// checkCredentials verifies the password against the stored hash, handling the attempts tracking of the account
+func checkCredentials(db *sql.DB, contactSender sender.Sender, clientType shared.ClientType, email string, password string, storedPassword string, exists bool) error {
+	err := checkLoginAllowed(db, clientType, email)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		isPasswordCorrect(password, string(dummyPasswordHash))
+	}
+
+	if !exists || !isPasswordCorrect(password, storedPassword) {
+		err = registerFailedLogin(db, contactSender, clientType, email, exists)
+		if err != nil {
+			return err
+		}
+
+		return ErrInvalidCredentials
+	}
+
+	return registerSuccessfulLogin(db, contactSender, clientType, email)
+}
+
+func login(db *sql.DB, contactSender sender.Sender, user *us.User) (*TokenPair, *usr.User, error) {
 	if user.Email == "" {
-		return "", nil, ErrMissingEmail
+		return nil, nil, ErrMissingEmail
 	}
 
 	if user.Password == "" {
-		return "", nil, ErrMissingPassword
+		return nil, nil, ErrMissingPassword
 	}
 
 	userRetrieved, err := us.GetUserByEmail(db, user.Email)
 	if err != nil && err != sql.ErrNoRows {
-		return "", nil, err
+		return nil, nil, err
 	}
 
-	// user does not exist
-	if err == sql.ErrNoRows || userRetrieved == nil {
-		return "", nil, ErrInvalidCredentials
+	storedPassword := ""
+	exists := err == nil && userRetrieved != nil
+	if exists {
+		storedPassword = userRetrieved.Password
 	}
 
-	if !isPasswordCorrect(user.Password, userRetrieved.Password) {
-		return "", nil, ErrInvalidCredentials
+	err = checkCredentials(db, contactSender, shared.ClientTypeUser, user.Email, user.Password, storedPassword, exists)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	token, err := GenerateToken(userRetrieved.UserID, shared.ClientTypeUser)
+	tokens, err := issueTokens(db, userRetrieved.UserID, shared.ClientTypeUser)
 	if err != nil {
-		return "", nil, err
+		return nil, nil, err
 	}
 
 	userRetrieved.Password = ""
 
-	return token, userRetrieved, nil
+	return tokens, userRetrieved, nil
+}
+
+func mechanicLogin(db *sql.DB, contactSender sender.Sender, mechanic mec.Mechanic) (*TokenPair, *mec.Mechanic, error) {
+	if mechanic.Email == "" {
+		return nil, nil, ErrMissingEmail
+	}
+
+	if mechanic.Password == "" {
+		return nil, nil, ErrMissingPassword
+	}
+
+	retrievedMechanic, err := mec.GetMechanicByEmail(db, mechanic.Email)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	storedPassword := ""
+	exists := err == nil
+	if exists {
+		storedPassword = retrievedMechanic.Password
+	}
+
+	err = checkCredentials(db, contactSender, shared.ClientTypeMechanic, mechanic.Email, mechanic.Password, storedPassword, exists)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens, err := issueLoginTokens(db, retrievedMechanic.MechanicID, shared.ClientTypeMechanic)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	retrievedMechanic.Password = ""
+
+	return tokens, retrievedMechanic, nil
+}
+
+func adminLogin(db *sql.DB, contactSender sender.Sender, admin adm.Admin) (*TokenPair, *adm.Admin, error) {
+	if admin.Email == "" {
+		return nil, nil, ErrMissingEmail
+	}
+
+	if admin.Password == "" {
+		return nil, nil, ErrMissingPassword
+	}
+
+	retrievedAdmin, err := adm.GetAdminByEmail(db, admin.Email)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, err
+	}
+
+	storedPassword := ""
+	exists := err == nil
+	if exists {
+		storedPassword = retrievedAdmin.Password
+	}
+
+	err = checkCredentials(db, contactSender, shared.ClientTypeAdmin, admin.Email, admin.Password, storedPassword, exists)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens, err := issueLoginTokens(db, retrievedAdmin.AdminID, shared.ClientTypeAdmin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	retrievedAdmin.Password = ""
+
+	return tokens, retrievedAdmin, nil
 }
 
 func validateSignUpFields(user us.User) error {
@@ -123,14 +240,25 @@ func CreateUser(db *sql.DB, user *usr.User) (*usr.User, error) {
 
 // GenerateToken returns the jwt for the client logged in
 func GenerateToken(id int, clientType shared.ClientType) (string, error) {
+	jti, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	keySet, err := jwtkeys.Default()
+	if err != nil {
+		log.Println("error_loading_jwt_keys: " + err.Error())
+		return "", err
+	}
+
 	now := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	signedToken, err := keySet.Sign(jwt.MapClaims{
 		"type": clientType,
 		"id":   id,
-		"iat":  now,
+		"iat":  now.Unix(),
+		"exp":  now.Add(accessTokenDuration).Unix(),
+		"jti":  jti,
 	})
-
-	signedToken, err := token.SignedString([]byte(os.Getenv("SECRET")))
 	if err != nil {
 		log.Println("error_signing_token: " + err.Error())
 		return "", err
@@ -141,36 +269,227 @@ func GenerateToken(id int, clientType shared.ClientType) (string, error) {
 
 // GenerateMechanicToken returns the jwt for the mechanic logged in
 func GenerateMechanicToken(mechanic mec.Mechanic) (string, error) {
-	now := time.Now()
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"id":   mechanic.MechanicID,
-		"type": shared.ClientTypeMechanic,
-		"iat":  now.String(),
-	})
+	return GenerateToken(mechanic.MechanicID, shared.ClientTypeMechanic)
+}
+
+// hashToken hashes the opaque tokens and codes before storing them
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
 
-	signedToken, err := token.SignedString([]byte(os.Getenv("SECRET")))
+func generateOpaqueToken() (string, error) {
+	tokenBytes := make([]byte, 32)
+	_, err := rand.Read(tokenBytes)
 	if err != nil {
-		log.Println("error_signing_token: " + err.Error())
 		return "", err
 	}
 
-	return signedToken, nil
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+func generateRefreshToken(db *sql.DB, id int, clientType shared.ClientType, familyID string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = insertRefreshToken(db, RefreshToken{
+		TokenHash:  hashToken(token),
+		FamilyID:   familyID,
+		ClientType: clientType,
+		ClientID:   id,
+		ExpiresAt:  time.Now().Add(refreshTokenDuration),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// issueTokens returns a new access token and a refresh token starting a new family
+func issueTokens(db *sql.DB, id int, clientType shared.ClientType) (*TokenPair, error) {
+	familyID, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokensForFamily(db, id, clientType, familyID)
+}
+
+func issueTokensForFamily(db *sql.DB, id int, clientType shared.ClientType, familyID string) (*TokenPair, error) {
+	token, err := GenerateToken(id, clientType)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := generateRefreshToken(db, id, clientType, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{Token: token, RefreshToken: refreshToken}, nil
+}
+
+// refreshTokens rotates the given refresh token. If a token that was already rotated is used again
+// the whole family is revoked, since either the client or an attacker is holding a stolen token
+func refreshTokens(db *sql.DB, token string) (*TokenPair, error) {
+	if token == "" {
+		return nil, ErrMissingRefreshToken
+	}
+
+	refreshToken, err := getRefreshTokenByHash(db, hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if refreshToken.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if refreshToken.UsedAt != nil {
+		log.Println("refresh_token_reuse_detected, family: " + refreshToken.FamilyID)
+		err = revokeRefreshTokenFamily(db, refreshToken.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if time.Now().After(refreshToken.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	marked, err := markRefreshTokenUsed(db, refreshToken.RefreshTokenID)
+	if err != nil {
+		return nil, err
+	}
+
+	// another request rotated the token first
+	if !marked {
+		err = revokeRefreshTokenFamily(db, refreshToken.FamilyID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return issueTokensForFamily(db, refreshToken.ClientID, refreshToken.ClientType, refreshToken.FamilyID)
 }
 
 // UserAuthenticationMiddleware middleware for the user's restricted endpoints
-func UserAuthenticationMiddleware(r *http.Request) (shared.ClientType, int, error) {
+func UserAuthenticationMiddleware(db *sql.DB, r *http.Request) (shared.ClientType, int, error) {
+	claims, err := authenticate(db, r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return claims.ClientType, claims.ID, nil
+}
+
+func authenticate(db *sql.DB, r *http.Request) (*shared.TokenClaims, error) {
 	token := r.Header["Authorization"]
 	if len(token) == 0 || token[0] == "" {
-		return "", 0, ErrMissingToken
+		return nil, ErrMissingToken
 	}
 
-	clientType, id, err := utils.DecodeToken(token[0])
+	claims, err := utils.DecodeTokenClaims(token[0])
 	if err != nil {
 		log.Println(err.Error())
-		return "", 0, err
+		return nil, err
+	}
+
+	if claims.MFAPending {
+		return nil, ErrMFAPending
+	}
+
+	revoked, err := isTokenRevoked(db, *claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, ErrRevokedToken
+	}
+
+	return claims, nil
+}
+
+// RequireClientType returns a middleware that only lets through authenticated clients of the given types
+func RequireClientType(db *sql.DB, clientTypes ...shared.ClientType) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientType, _, err := UserAuthenticationMiddleware(db, r)
+			if err != nil {
+				utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+				return
+			}
+
+			for _, allowedType := range clientTypes {
+				if clientType == allowedType {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			utils.RespondWithError(w, http.StatusForbidden, "client is not allowed to perform the request")
+		})
+	}
+}
+
+// logout revokes the access token in use and, if given, the refresh token and device of the session
+func logout(db *sql.DB, claims shared.TokenClaims, refreshToken string, deviceToken string) error {
+	err := insertRevokedToken(db, claims.JTI, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return err
+	}
+
+	if refreshToken != "" {
+		storedToken, err := getRefreshTokenByHash(db, hashToken(refreshToken))
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if err == nil && storedToken.ClientType == claims.ClientType && storedToken.ClientID == claims.ID {
+			err = revokeRefreshTokenFamily(db, storedToken.FamilyID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if deviceToken != "" {
+		return deleteSessionByDeviceToken(db, claims.ID, claims.ClientType, deviceToken)
+	}
+
+	return nil
+}
+
+// RevokeAllSessions ends every session of the client
+func RevokeAllSessions(db *sql.DB, clientType shared.ClientType, id int) error {
+	return logoutAll(db, shared.TokenClaims{ClientType: clientType, ID: id})
+}
+
+// logoutAll revokes every token issued to the client and forgets all of its devices
+func logoutAll(db *sql.DB, claims shared.TokenClaims) error {
+	err := revokeClientTokens(db, claims.ClientType, claims.ID)
+	if err != nil {
+		return err
+	}
+
+	err = revokeClientRefreshTokens(db, claims.ClientType, claims.ID)
+	if err != nil {
+		return err
 	}
 
-	return clientType, id, err
+	return deleteClientSessions(db, claims.ID, claims.ClientType)
 }
 
 func isPasswordCorrect(enteredPassword string, storedPassword string) bool {
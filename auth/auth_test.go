@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/CartechAPI/shared"
+	"github.com/CartechAPI/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+// testSecret is the HS256 secret the tests sign with, long enough to be accepted by the keyset
+const testSecret = "test-secret-that-is-at-least-32-bytes"
+
+func TestGenerateToken(t *testing.T) {
+	c := require.New(t)
+	os.Setenv("SECRET", testSecret)
+
+	token, err := GenerateToken(7, shared.ClientTypeMechanic)
+	c.Nil(err)
+
+	clientType, id, err := utils.DecodeToken(token)
+	c.Nil(err)
+	c.Equal(shared.ClientTypeMechanic, clientType)
+	c.Equal(7, id)
+
+	claims, err := utils.DecodeTokenClaims(token)
+	c.Nil(err)
+	c.NotEmpty(claims.JTI)
+}
+
+func TestDecodeExpiredToken(t *testing.T) {
+	c := require.New(t)
+	os.Setenv("SECRET", testSecret)
+
+	issuedAt := time.Now().Add(-time.Hour)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"type": shared.ClientTypeUser,
+		"id":   1,
+		"iat":  issuedAt.Unix(),
+		"exp":  issuedAt.Add(accessTokenDuration).Unix(),
+	}).SignedString([]byte(testSecret))
+	c.Nil(err)
+
+	_, _, err = utils.DecodeToken(token)
+	c.Equal(utils.ErrExpiredToken, err)
+}
+
+func TestHashToken(t *testing.T) {
+	c := require.New(t)
+
+	c.Equal(hashToken("token"), hashToken("token"))
+	c.NotEqual(hashToken("token"), hashToken("another-token"))
+}
+
+func TestGenerateNumericCode(t *testing.T) {
+	c := require.New(t)
+
+	code, err := generateNumericCode(6)
+	c.Nil(err)
+	c.Len(code, 6)
+	c.Regexp("^[0-9]{6}$", code)
+}
+
+func TestVerificationPolicyIsSatisfiedBy(t *testing.T) {
+	c := require.New(t)
+
+	c.True(VerificationPolicyNone.isSatisfiedBy(false, false))
+	c.True(VerificationPolicyEmail.isSatisfiedBy(true, false))
+	c.False(VerificationPolicyEmail.isSatisfiedBy(false, true))
+	c.True(VerificationPolicyPhone.isSatisfiedBy(false, true))
+	c.False(VerificationPolicyAll.isSatisfiedBy(true, false))
+	c.True(VerificationPolicyAll.isSatisfiedBy(true, true))
+}
+
+func TestParseVerificationPolicy(t *testing.T) {
+	c := require.New(t)
+
+	policy, err := parseVerificationPolicy("")
+	c.Nil(err)
+	c.Equal(VerificationPolicyNone, policy)
+
+	policy, err = parseVerificationPolicy("phone")
+	c.Nil(err)
+	c.Equal(VerificationPolicyPhone, policy)
+
+	_, err = parseVerificationPolicy("Email")
+	c.Equal(ErrUnknownVerificationPolicy, err)
+
+	_, err = parseVerificationPolicy("phones")
+	c.Equal(ErrUnknownVerificationPolicy, err)
+
+	c.False(VerificationPolicy("phones").isSatisfiedBy(true, true))
+}
+
+func TestLoginDelay(t *testing.T) {
+	c := require.New(t)
+
+	c.Equal(time.Duration(0), loginDelay(0))
+	c.Equal(time.Duration(0), loginDelay(freeLoginAttempts-1))
+	c.Equal(time.Second, loginDelay(freeLoginAttempts))
+	c.Equal(4*time.Second, loginDelay(freeLoginAttempts+2))
+	c.Equal(maxLoginDelay, loginDelay(maxLoginAttempts+20))
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	c := require.New(t)
+
+	codes, err := generateRecoveryCodes()
+	c.Nil(err)
+	c.Len(codes, recoveryCodesCount)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		c.Regexp("^[a-z2-7]{4}-[a-z2-7]{4}$", code)
+		c.False(seen[code])
+		seen[code] = true
+	}
+}
+
+func TestMFAPendingTokenIsNotAnAccessToken(t *testing.T) {
+	c := require.New(t)
+	os.Setenv("SECRET", testSecret)
+
+	token, err := generateMFAPendingToken(3, shared.ClientTypeMechanic)
+	c.Nil(err)
+
+	claims, err := utils.DecodeTokenClaims(token)
+	c.Nil(err)
+	c.True(claims.MFAPending)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", token)
+
+	_, err = authenticate(nil, r)
+	c.Equal(ErrMFAPending, err)
+}
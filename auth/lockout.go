@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CartechAPI/sender"
+	"github.com/CartechAPI/shared"
+)
+
+const (
+	// freeLoginAttempts is the number of failed attempts allowed before delaying the next ones
+	freeLoginAttempts = 3
+	// maxLoginAttempts is the number of failed attempts after which the account gets locked
+	maxLoginAttempts = 10
+	// maxMFAAttempts is the number of wrong two factor codes after which the mfa token is revoked and the account locked
+	maxMFAAttempts = 5
+	// maxLoginDelay is the longest delay between attempts before the lockout
+	maxLoginDelay = 2 * time.Minute
+	// lockoutDuration is how long an account stays locked
+	lockoutDuration = 15 * time.Minute
+)
+
+// LoginAttempts holds the failed login attempts of an account
+type LoginAttempts struct {
+	FailedAttempts int
+	LastFailedAt   time.Time
+	LockedUntil    *time.Time
+}
+
+// loginDelay returns how long a client has to wait after the given number of failed attempts
+func loginDelay(failedAttempts int) time.Duration {
+	if failedAttempts < freeLoginAttempts {
+		return 0
+	}
+
+	delay := time.Duration(math.Pow(2, float64(failedAttempts-freeLoginAttempts))) * time.Second
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+
+	return delay
+}
+
+func newTooManyAttemptsError(wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	return shared.NewShowableError(fmt.Sprintf("too many failed attempts, try again in %d seconds", seconds), http.StatusTooManyRequests)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginAllowed returns an error if the account is locked or has to wait before trying again
+func checkLoginAllowed(db *sql.DB, clientType shared.ClientType, email string) error {
+	attempts, err := getLoginAttempts(db, clientType, normalizeEmail(email))
+	if err != nil {
+		return err
+	}
+
+	if attempts == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if attempts.LockedUntil != nil && now.Before(*attempts.LockedUntil) {
+		return newTooManyAttemptsError(attempts.LockedUntil.Sub(now))
+	}
+
+	if attempts.LockedUntil != nil {
+		return nil
+	}
+
+	nextAttemptAt := attempts.LastFailedAt.Add(loginDelay(attempts.FailedAttempts))
+	if now.Before(nextAttemptAt) {
+		return newTooManyAttemptsError(nextAttemptAt.Sub(now))
+	}
+
+	return nil
+}
+
+// registerFailedLogin counts the failed attempt and locks the account once it reaches the limit. Attempts on
+// emails without an account are counted too, so both behave the same, but nobody is emailed about them
+func registerFailedLogin(db *sql.DB, contactSender sender.Sender, clientType shared.ClientType, email string, exists bool) error {
+	email = normalizeEmail(email)
+
+	failedAttempts, err := incrementFailedLogins(db, clientType, email)
+	if err != nil {
+		return err
+	}
+
+	if failedAttempts < maxLoginAttempts {
+		return nil
+	}
+
+	return lockAccount(db, contactSender, clientType, email, exists)
+}
+
+// registerFailedMFA counts a wrong two factor code on the same counter as the passwords, which the password
+// step already cleared. Once it reaches the limit the mfa token stops being accepted and the account is locked
+func registerFailedMFA(db *sql.DB, contactSender sender.Sender, claims shared.TokenClaims, email string) error {
+	email = normalizeEmail(email)
+
+	failedAttempts, err := incrementFailedLogins(db, claims.ClientType, email)
+	if err != nil {
+		return err
+	}
+
+	if failedAttempts < maxMFAAttempts {
+		return nil
+	}
+
+	err = insertRevokedToken(db, claims.JTI, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return err
+	}
+
+	return lockAccount(db, contactSender, claims.ClientType, email, true)
+}
+
+// lockAccount locks the account for the lockout duration, notifying the owner when there is one
+func lockAccount(db *sql.DB, contactSender sender.Sender, clientType shared.ClientType, email string, notify bool) error {
+	err := lockLogin(db, clientType, email, time.Now().Add(lockoutDuration))
+	if err != nil {
+		return err
+	}
+
+	log.Println("account_locked_after_failed_logins")
+
+	if !notify {
+		return nil
+	}
+
+	body := fmt.Sprintf("Bloqueamos el acceso a tu cuenta por %d minutos debido a varios intentos fallidos de inicio de sesion. Si no fuiste tu, te recomendamos restablecer tu contraseña.", int(lockoutDuration.Minutes()))
+	err = contactSender.SendEmail(email, "Tu cuenta fue bloqueada temporalmente", body)
+	if err != nil {
+		log.Println("error_sending_lockout_notification: " + err.Error())
+	}
+
+	return nil
+}
+
+// registerSuccessfulLogin clears the failed attempts, notifying the client if the account had been locked
+func registerSuccessfulLogin(db *sql.DB, contactSender sender.Sender, clientType shared.ClientType, email string) error {
+	email = normalizeEmail(email)
+
+	attempts, err := getLoginAttempts(db, clientType, email)
+	if err != nil {
+		return err
+	}
+
+	if attempts == nil {
+		return nil
+	}
+
+	err = deleteLoginAttempts(db, clientType, email)
+	if err != nil {
+		return err
+	}
+
+	if attempts.LockedUntil != nil {
+		err = contactSender.SendEmail(email, "Tu cuenta fue desbloqueada", "El bloqueo de tu cuenta termino y acabas de iniciar sesion correctamente.")
+		if err != nil {
+			log.Println("error_sending_lockout_cleared_notification: " + err.Error())
+		}
+	}
+
+	return nil
+}
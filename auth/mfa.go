@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CartechAPI/jwtkeys"
+	"github.com/CartechAPI/sender"
+	"github.com/CartechAPI/shared"
+	"github.com/CartechAPI/totp"
+	"github.com/CartechAPI/utils"
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrMFAPending the token can only be used to complete the two factor login
+	ErrMFAPending = shared.NewShowableError("two factor verification required", http.StatusUnauthorized)
+	// ErrInvalidMFAToken invalid mfa token
+	ErrInvalidMFAToken = shared.NewShowableError("invalid mfa token", http.StatusUnauthorized)
+	// ErrInvalidMFACode invalid two factor code
+	ErrInvalidMFACode = shared.NewBadRequestError("invalid two factor code")
+	// ErrMFAAlreadyEnabled two factor authentication already enabled
+	ErrMFAAlreadyEnabled = shared.NewShowableError("two factor authentication already enabled", http.StatusConflict)
+	// ErrMFANotEnrolled two factor authentication was not enrolled
+	ErrMFANotEnrolled = shared.NewShowableError("two factor authentication not enrolled", http.StatusConflict)
+)
+
+const (
+	// mfaIssuer is the name shown by the authenticator apps
+	mfaIssuer = "Cartech"
+	// mfaTokenDuration is how long a client has to enter the code after the password
+	mfaTokenDuration = 5 * time.Minute
+	// recoveryCodesCount is the number of recovery codes given on enrollment
+	recoveryCodesCount = 10
+)
+
+// MFAEnrollment is the data needed to add the account to an authenticator app
+type MFAEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+func generateMFAPendingToken(id int, clientType shared.ClientType) (string, error) {
+	jti, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	keySet, err := jwtkeys.Default()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	return keySet.Sign(jwt.MapClaims{
+		"type":        clientType,
+		"id":          id,
+		"iat":         now.Unix(),
+		"exp":         now.Add(mfaTokenDuration).Unix(),
+		"jti":         jti,
+		"mfa_pending": true,
+	})
+}
+
+// issueLoginTokens returns the tokens for a client that entered the right password, asking for the
+// second factor if it is enabled
+func issueLoginTokens(db *sql.DB, id int, clientType shared.ClientType) (*TokenPair, error) {
+	mfa, err := getMFA(db, clientType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa == nil || !mfa.Enabled {
+		return issueTokens(db, id, clientType)
+	}
+
+	mfaToken, err := generateMFAPendingToken(id, clientType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{MFARequired: true, MFAToken: mfaToken}, nil
+}
+
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		codeBytes := make([]byte, 5)
+		_, err := rand.Read(codeBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(codeBytes))
+		codes[i] = code[:4] + "-" + code[4:]
+	}
+
+	return codes, nil
+}
+
+// enrollMFA generates a new secret for the client, it is not enabled until a code is confirmed
+func enrollMFA(db *sql.DB, clientType shared.ClientType, id int) (*MFAEnrollment, error) {
+	mfa, err := getMFA(db, clientType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa != nil && mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	email, _, _, _, err := contactDetails(db, clientType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	err = upsertMFASecret(db, clientType, id, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollment{Secret: secret, URI: totp.URI(mfaIssuer, email, secret)}, nil
+}
+
+// confirmMFA enables two factor authentication and returns the recovery codes, which are only shown once
+func confirmMFA(db *sql.DB, clientType shared.ClientType, id int, code string) ([]string, error) {
+	mfa, err := getMFA(db, clientType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if mfa == nil {
+		return nil, ErrMFANotEnrolled
+	}
+
+	if mfa.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	step, ok := totp.Verify(code, mfa.Secret, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, len(recoveryCodes))
+	for i, recoveryCode := range recoveryCodes {
+		hashes[i] = hashToken(recoveryCode)
+	}
+
+	err = enableMFA(db, clientType, id, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// checkMFACode accepts either a totp code, which can not be replayed, or an unused recovery code
+func checkMFACode(db *sql.DB, clientType shared.ClientType, id int, code string, recoveryCode string) error {
+	mfa, err := getMFA(db, clientType, id)
+	if err != nil {
+		return err
+	}
+
+	if mfa == nil || !mfa.Enabled {
+		return ErrMFANotEnrolled
+	}
+
+	if recoveryCode != "" {
+		used, err := useRecoveryCode(db, clientType, id, hashToken(strings.ToLower(strings.TrimSpace(recoveryCode))))
+		if err != nil {
+			return err
+		}
+
+		if !used {
+			return ErrInvalidMFACode
+		}
+
+		return nil
+	}
+
+	step, ok := totp.Verify(code, mfa.Secret, time.Now())
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	used, err := useMFAStep(db, clientType, id, step)
+	if err != nil {
+		return err
+	}
+
+	if !used {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// verifyMFALogin completes the login of a client holding an mfa token. Wrong codes are counted like wrong
+// passwords, so the token can not be used to guess the code
+func verifyMFALogin(db *sql.DB, contactSender sender.Sender, mfaToken string, code string, recoveryCode string) (*TokenPair, error) {
+	claims, err := utils.DecodeTokenClaims(mfaToken)
+	if err != nil || !claims.MFAPending {
+		return nil, ErrInvalidMFAToken
+	}
+
+	revoked, err := isTokenRevoked(db, *claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if revoked {
+		return nil, ErrInvalidMFAToken
+	}
+
+	email, _, _, _, err := contactDetails(db, claims.ClientType, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLoginAllowed(db, claims.ClientType, email)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkMFACode(db, claims.ClientType, claims.ID, code, recoveryCode)
+	if err == ErrInvalidMFACode {
+		registerErr := registerFailedMFA(db, contactSender, *claims, email)
+		if registerErr != nil {
+			return nil, registerErr
+		}
+
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the mfa token can only be exchanged once
+	err = insertRevokedToken(db, claims.JTI, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	err = registerSuccessfulLogin(db, contactSender, claims.ClientType, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return issueTokens(db, claims.ID, claims.ClientType)
+}
+
+// disableMFA turns off two factor authentication, a valid code is required
+func disableMFA(db *sql.DB, clientType shared.ClientType, id int, code string, recoveryCode string) error {
+	err := checkMFACode(db, clientType, id, code, recoveryCode)
+	if err != nil {
+		return err
+	}
+
+	return deleteMFA(db, clientType, id)
+}
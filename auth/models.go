@@ -14,3 +14,34 @@ type Session struct {
 	UserType  shared.ClientType `json:"user_type"`
 	Token     string            `json:"token"`
 }
+
+// RefreshToken represents a long lived token used to get new access tokens
+type RefreshToken struct {
+	RefreshTokenID int
+	TokenHash      string
+	FamilyID       string
+	ClientType     shared.ClientType
+	ClientID       int
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	UsedAt         *time.Time
+	RevokedAt      *time.Time
+}
+
+// TokenPair is the pair of tokens given to a client when it logs in. When the client has two factor
+// authentication enabled only the mfa token is given until the code is verified
+type TokenPair struct {
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	MFARequired  bool   `json:"mfa_required,omitempty"`
+	MFAToken     string `json:"mfa_token,omitempty"`
+}
+
+// MFA is the two factor authentication configuration of a client
+type MFA struct {
+	ClientType   shared.ClientType
+	ClientID     int
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+}
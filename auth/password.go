@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/sender"
+	"github.com/CartechAPI/shared"
+	us "github.com/CartechAPI/user"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrMissingCode missing code
+	ErrMissingCode = shared.NewBadRequestError("missing code")
+	// ErrInvalidResetCode invalid or expired reset code
+	ErrInvalidResetCode = shared.NewBadRequestError("invalid or expired code")
+	// ErrMissingCurrentPassword missing current password
+	ErrMissingCurrentPassword = shared.NewBadRequestError("missing current password")
+	// ErrIncorrectPassword the current password is not correct
+	ErrIncorrectPassword = shared.NewBadRequestError("incorrect current password")
+)
+
+const (
+	// passwordResetCodeDuration is how long a reset code can be used
+	passwordResetCodeDuration = 15 * time.Minute
+	// maxPasswordResetAttempts is the number of wrong codes after which the code stops working
+	maxPasswordResetAttempts = 5
+)
+
+// generateNumericCode returns a random code of the given number of digits
+func generateNumericCode(digits int) (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < digits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
+
+// getClientIDByEmail returns the id of the user or mechanic with the given email, or 0 if there is none
+func getClientIDByEmail(db *sql.DB, clientType shared.ClientType, email string) (int, error) {
+	switch clientType {
+	case shared.ClientTypeUser:
+		user, err := us.GetUserByEmail(db, email)
+		if err != nil || user == nil {
+			return 0, err
+		}
+
+		return user.UserID, nil
+	case shared.ClientTypeMechanic:
+		mechanic, err := mec.GetMechanicByEmail(db, email)
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		return mechanic.MechanicID, nil
+	}
+
+	return 0, nil
+}
+
+func updateClientPassword(db *sql.DB, clientType shared.ClientType, id int, hashedPassword string) error {
+	if clientType == shared.ClientTypeMechanic {
+		return mec.UpdateMechanicPassword(db, id, hashedPassword)
+	}
+
+	return us.UpdateUserPassword(db, id, hashedPassword)
+}
+
+// forgotPassword sends a reset code to the client's email. It does not tell whether the email exists
+func forgotPassword(db *sql.DB, contactSender sender.Sender, clientType shared.ClientType, email string) error {
+	if email == "" {
+		return ErrMissingEmail
+	}
+
+	id, err := getClientIDByEmail(db, clientType, email)
+	if err != nil {
+		return err
+	}
+
+	if id == 0 {
+		log.Println("password_reset_requested_for_unknown_email")
+		return nil
+	}
+
+	code, err := generateNumericCode(6)
+	if err != nil {
+		return err
+	}
+
+	err = insertPasswordResetCode(db, clientType, id, hashToken(code), time.Now().Add(passwordResetCodeDuration))
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Tu codigo para restablecer la contraseña es %s. Expira en %d minutos.", code, int(passwordResetCodeDuration.Minutes()))
+
+	return contactSender.SendEmail(email, "Restablece tu contraseña", body)
+}
+
+// resetPassword replaces the client's password if the code is valid and ends all of its sessions
+func resetPassword(db *sql.DB, clientType shared.ClientType, email string, code string, password string) error {
+	if email == "" {
+		return ErrMissingEmail
+	}
+
+	if code == "" {
+		return ErrMissingCode
+	}
+
+	if password == "" {
+		return ErrMissingPassword
+	}
+
+	id, err := getClientIDByEmail(db, clientType, email)
+	if err != nil {
+		return err
+	}
+
+	if id == 0 {
+		return ErrInvalidResetCode
+	}
+
+	used, err := usePasswordResetCode(db, clientType, id, hashToken(code))
+	if err != nil {
+		return err
+	}
+
+	if !used {
+		err = registerFailedPasswordResetAttempt(db, clientType, id)
+		if err != nil {
+			return err
+		}
+
+		return ErrInvalidResetCode
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return err
+	}
+
+	err = updateClientPassword(db, clientType, id, string(hashedPassword))
+	if err != nil {
+		return err
+	}
+
+	return logoutAll(db, shared.TokenClaims{ClientType: clientType, ID: id})
+}
+
+// getStoredPassword returns the password hash of the client
+func getStoredPassword(db *sql.DB, clientType shared.ClientType, id int) (string, error) {
+	if clientType == shared.ClientTypeMechanic {
+		mechanic, err := mec.GetMechanicByID(db, id)
+		if err != nil {
+			return "", err
+		}
+
+		return mechanic.Password, nil
+	}
+
+	user, err := us.GetUserByID(db, id)
+	if err != nil {
+		return "", err
+	}
+
+	return user.Password, nil
+}
+
+// ChangePassword replaces the password of the client after checking the current one
+func ChangePassword(db *sql.DB, clientType shared.ClientType, id int, currentPassword string, password string) error {
+	if currentPassword == "" {
+		return ErrMissingCurrentPassword
+	}
+
+	if password == "" {
+		return ErrMissingPassword
+	}
+
+	storedPassword, err := getStoredPassword(db, clientType, id)
+	if err != nil {
+		return err
+	}
+
+	if !isPasswordCorrect(currentPassword, storedPassword) {
+		return ErrIncorrectPassword
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+	if err != nil {
+		return err
+	}
+
+	return updateClientPassword(db, clientType, id, string(hashedPassword))
+}
@@ -1,11 +1,21 @@
 package auth
 
-import "database/sql"
+import (
+	"database/sql"
+	"log"
+	"time"
 
+	"github.com/CartechAPI/shared"
+	"github.com/lib/pq"
+)
+
+// saveSession stores the device token of the client. A device belongs to the last client that registered it
 func saveSession(db *sql.DB, session Session) (*Session, error) {
 	query := `INSERT INTO sessions 
 	(created_at, user_id, user_type, device_token)
-	VALUES (NOW(), $1, $2, $3) RETURNING session_id, created_at`
+	VALUES (NOW(), $1, $2, $3)
+	ON CONFLICT (device_token) DO UPDATE SET user_id = $1, user_type = $2, created_at = NOW()
+	RETURNING session_id, created_at`
 
 	err := db.QueryRow(query, session.UserID, session.UserType, session.Token).Scan(&session.SessionID, &session.CreatedAt)
 	if err != nil {
@@ -15,11 +25,555 @@ func saveSession(db *sql.DB, session Session) (*Session, error) {
 	return &session, nil
 }
 
-func GetLastSession(db *sql.DB, id int) (*Session, error) {
-	query := `SELECT * FROM sessions WHERE user_id = $1 ORDER BY created_at LIMIT 1`
+func getClientSession(db *sql.DB, sessionID int, clientType shared.ClientType, clientID int) (*Session, error) {
+	query := `SELECT session_id, created_at, user_id, user_type, device_token FROM sessions WHERE session_id = $1 AND user_id = $2 AND user_type = $3`
 
 	session := Session{}
-	err := db.QueryRow(query, id).Scan(&session.SessionID, &session.CreatedAt, &session.UserID, &session.UserType, &session.Token)
+	err := db.QueryRow(query, sessionID, clientID, clientType).Scan(&session.SessionID, &session.CreatedAt, &session.UserID, &session.UserType, &session.Token)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("error selecting from sessions: " + err.Error())
+		}
+
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// deleteClientSession deletes the session if it belongs to the client, it returns false if there was none
+func deleteClientSession(db *sql.DB, sessionID int, clientType shared.ClientType, clientID int) (bool, error) {
+	query := "DELETE FROM sessions WHERE session_id = $1 AND user_id = $2 AND user_type = $3"
+
+	result, err := db.Exec(query, sessionID, clientID, clientType)
+	if err != nil {
+		log.Println("error deleting from sessions: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// ForgetDevice forgets a device, used when the push provider reports its token is no longer valid
+func ForgetDevice(db *sql.DB, deviceToken string) error {
+	query := "DELETE FROM sessions WHERE device_token = $1"
+
+	_, err := db.Exec(query, deviceToken)
+	if err != nil {
+		log.Println("error deleting from sessions: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func insertRefreshToken(db *sql.DB, refreshToken RefreshToken) error {
+	query := `INSERT INTO refresh_token_table
+	(token_hash, family_id, client_type, client_id, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, NOW(), $5)`
+
+	_, err := db.Exec(query, refreshToken.TokenHash, refreshToken.FamilyID, refreshToken.ClientType, refreshToken.ClientID, refreshToken.ExpiresAt)
+	if err != nil {
+		log.Println("error inserting into refresh_token_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func getRefreshTokenByHash(db *sql.DB, tokenHash string) (*RefreshToken, error) {
+	query := `SELECT refresh_token_id, token_hash, family_id, client_type, client_id, created_at, expires_at, used_at, revoked_at
+	FROM refresh_token_table WHERE token_hash = $1`
+
+	refreshToken := RefreshToken{}
+	var usedAt, revokedAt sql.NullTime
+	err := db.QueryRow(query, tokenHash).Scan(&refreshToken.RefreshTokenID, &refreshToken.TokenHash, &refreshToken.FamilyID, &refreshToken.ClientType,
+		&refreshToken.ClientID, &refreshToken.CreatedAt, &refreshToken.ExpiresAt, &usedAt, &revokedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("error selecting from refresh_token_table: " + err.Error())
+		}
+
+		return nil, err
+	}
+
+	if usedAt.Valid {
+		refreshToken.UsedAt = &usedAt.Time
+	}
+
+	if revokedAt.Valid {
+		refreshToken.RevokedAt = &revokedAt.Time
+	}
+
+	return &refreshToken, nil
+}
+
+// markRefreshTokenUsed flags the token as used, it returns false if it was already used
+func markRefreshTokenUsed(db *sql.DB, refreshTokenID int) (bool, error) {
+	query := "UPDATE refresh_token_table SET used_at = NOW() WHERE refresh_token_id = $1 AND used_at IS NULL AND revoked_at IS NULL"
+
+	result, err := db.Exec(query, refreshTokenID)
+	if err != nil {
+		log.Println("error updating refresh_token_table: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func revokeRefreshTokenFamily(db *sql.DB, familyID string) error {
+	query := "UPDATE refresh_token_table SET revoked_at = NOW() WHERE family_id = $1 AND revoked_at IS NULL"
+
+	_, err := db.Exec(query, familyID)
+	if err != nil {
+		log.Println("error revoking refresh token family: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func revokeClientRefreshTokens(db *sql.DB, clientType shared.ClientType, clientID int) error {
+	query := "UPDATE refresh_token_table SET revoked_at = NOW() WHERE client_type = $1 AND client_id = $2 AND revoked_at IS NULL"
+
+	_, err := db.Exec(query, clientType, clientID)
+	if err != nil {
+		log.Println("error revoking client refresh tokens: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func insertRevokedToken(db *sql.DB, jti string, expiresAt time.Time) error {
+	query := "INSERT INTO revoked_token_table (jti, revoked_at, expires_at) VALUES ($1, NOW(), $2) ON CONFLICT (jti) DO NOTHING"
+
+	_, err := db.Exec(query, jti, expiresAt)
+	if err != nil {
+		log.Println("error inserting into revoked_token_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// revokeClientTokens revokes every token issued to the client until now
+func revokeClientTokens(db *sql.DB, clientType shared.ClientType, clientID int) error {
+	query := `INSERT INTO client_revocation_table (client_type, client_id, revoked_at) VALUES ($1, $2, NOW())
+	ON CONFLICT (client_type, client_id) DO UPDATE SET revoked_at = NOW()`
+
+	_, err := db.Exec(query, clientType, clientID)
+	if err != nil {
+		log.Println("error upserting client_revocation_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// isTokenRevoked tells if the token itself or every token of its client issued before it was revoked. The iat of
+// the tokens only has seconds, so the revocation is compared at the same precision, otherwise a token issued right
+// after the revocation, on the same second, would be taken as revoked
+func isTokenRevoked(db *sql.DB, claims shared.TokenClaims) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM revoked_token_table WHERE jti = $1)
+	OR EXISTS (SELECT 1 FROM client_revocation_table
+		WHERE client_type = $2 AND client_id = $3 AND date_trunc('second', revoked_at) > to_timestamp($4))`
+
+	revoked := false
+	err := db.QueryRow(query, claims.JTI, claims.ClientType, claims.ID, claims.IAT).Scan(&revoked)
+	if err != nil {
+		log.Println("error checking token revocation: " + err.Error())
+		return false, err
+	}
+
+	return revoked, nil
+}
+
+func deleteSessionByDeviceToken(db *sql.DB, clientID int, clientType shared.ClientType, deviceToken string) error {
+	query := "DELETE FROM sessions WHERE user_id = $1 AND user_type = $2 AND device_token = $3"
+
+	_, err := db.Exec(query, clientID, clientType, deviceToken)
+	if err != nil {
+		log.Println("error deleting from sessions: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func deleteClientSessions(db *sql.DB, clientID int, clientType shared.ClientType) error {
+	query := "DELETE FROM sessions WHERE user_id = $1 AND user_type = $2"
+
+	_, err := db.Exec(query, clientID, clientType)
+	if err != nil {
+		log.Println("error deleting from sessions: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// insertPasswordResetCode stores a new reset code, invalidating the previous ones of the client
+func insertPasswordResetCode(db *sql.DB, clientType shared.ClientType, clientID int, codeHash string, expiresAt time.Time) error {
+	invalidateQuery := "UPDATE password_reset_table SET used_at = NOW() WHERE client_type = $1 AND client_id = $2 AND used_at IS NULL"
+	_, err := db.Exec(invalidateQuery, clientType, clientID)
+	if err != nil {
+		log.Println("error invalidating password reset codes: " + err.Error())
+		return err
+	}
+
+	query := `INSERT INTO password_reset_table (client_type, client_id, code_hash, attempts, created_at, expires_at)
+	VALUES ($1, $2, $3, 0, NOW(), $4)`
+
+	_, err = db.Exec(query, clientType, clientID, codeHash, expiresAt)
+	if err != nil {
+		log.Println("error inserting into password_reset_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// usePasswordResetCode marks the code as used, it returns false if there was no valid code to use
+func usePasswordResetCode(db *sql.DB, clientType shared.ClientType, clientID int, codeHash string) (bool, error) {
+	query := `UPDATE password_reset_table SET used_at = NOW()
+	WHERE client_type = $1 AND client_id = $2 AND code_hash = $3 AND used_at IS NULL AND expires_at > NOW() AND attempts < $4`
+
+	result, err := db.Exec(query, clientType, clientID, codeHash, maxPasswordResetAttempts)
+	if err != nil {
+		log.Println("error updating password_reset_table: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func registerFailedPasswordResetAttempt(db *sql.DB, clientType shared.ClientType, clientID int) error {
+	query := "UPDATE password_reset_table SET attempts = attempts + 1 WHERE client_type = $1 AND client_id = $2 AND used_at IS NULL"
+
+	_, err := db.Exec(query, clientType, clientID)
+	if err != nil {
+		log.Println("error updating password_reset_table attempts: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// insertVerificationCode stores a new verification code, invalidating the previous ones of the channel
+func insertVerificationCode(db *sql.DB, clientType shared.ClientType, clientID int, channel VerificationChannel, codeHash string, expiresAt time.Time) error {
+	invalidateQuery := "UPDATE verification_code_table SET used_at = NOW() WHERE client_type = $1 AND client_id = $2 AND channel = $3 AND used_at IS NULL"
+	_, err := db.Exec(invalidateQuery, clientType, clientID, channel)
+	if err != nil {
+		log.Println("error invalidating verification codes: " + err.Error())
+		return err
+	}
+
+	query := `INSERT INTO verification_code_table (client_type, client_id, channel, code_hash, attempts, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, 0, NOW(), $5)`
+
+	_, err = db.Exec(query, clientType, clientID, channel, codeHash, expiresAt)
+	if err != nil {
+		log.Println("error inserting into verification_code_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// useVerificationCode marks the code as used, it returns false if there was no valid code to use
+func useVerificationCode(db *sql.DB, clientType shared.ClientType, clientID int, channel VerificationChannel, codeHash string) (bool, error) {
+	query := `UPDATE verification_code_table SET used_at = NOW()
+	WHERE client_type = $1 AND client_id = $2 AND channel = $3 AND code_hash = $4 AND used_at IS NULL AND expires_at > NOW() AND attempts < $5`
+
+	result, err := db.Exec(query, clientType, clientID, channel, codeHash, maxVerificationAttempts)
+	if err != nil {
+		log.Println("error updating verification_code_table: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func registerFailedVerificationAttempt(db *sql.DB, clientType shared.ClientType, clientID int, channel VerificationChannel) error {
+	query := "UPDATE verification_code_table SET attempts = attempts + 1 WHERE client_type = $1 AND client_id = $2 AND channel = $3 AND used_at IS NULL"
+
+	_, err := db.Exec(query, clientType, clientID, channel)
+	if err != nil {
+		log.Println("error updating verification_code_table attempts: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func getLoginAttempts(db *sql.DB, clientType shared.ClientType, email string) (*LoginAttempts, error) {
+	query := "SELECT failed_attempts, last_failed_at, locked_until FROM login_attempt_table WHERE client_type = $1 AND email = $2"
+
+	attempts := LoginAttempts{}
+	var lockedUntil sql.NullTime
+	err := db.QueryRow(query, clientType, email).Scan(&attempts.FailedAttempts, &attempts.LastFailedAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		log.Println("error selecting from login_attempt_table: " + err.Error())
+		return nil, err
+	}
+
+	if lockedUntil.Valid {
+		attempts.LockedUntil = &lockedUntil.Time
+	}
+
+	return &attempts, nil
+}
+
+// incrementFailedLogins counts a failed login, starting over if the previous lockout already expired
+func incrementFailedLogins(db *sql.DB, clientType shared.ClientType, email string) (int, error) {
+	query := `INSERT INTO login_attempt_table (client_type, email, failed_attempts, last_failed_at)
+	VALUES ($1, $2, 1, NOW())
+	ON CONFLICT (client_type, email) DO UPDATE SET
+		failed_attempts = CASE WHEN login_attempt_table.locked_until IS NOT NULL AND login_attempt_table.locked_until <= NOW()
+			THEN 1 ELSE login_attempt_table.failed_attempts + 1 END,
+		locked_until = CASE WHEN login_attempt_table.locked_until <= NOW() THEN NULL ELSE login_attempt_table.locked_until END,
+		last_failed_at = NOW()
+	RETURNING failed_attempts`
+
+	failedAttempts := 0
+	err := db.QueryRow(query, clientType, email).Scan(&failedAttempts)
+	if err != nil {
+		log.Println("error upserting login_attempt_table: " + err.Error())
+		return 0, err
+	}
+
+	return failedAttempts, nil
+}
+
+func lockLogin(db *sql.DB, clientType shared.ClientType, email string, lockedUntil time.Time) error {
+	query := "UPDATE login_attempt_table SET locked_until = $1 WHERE client_type = $2 AND email = $3"
+
+	_, err := db.Exec(query, lockedUntil, clientType, email)
+	if err != nil {
+		log.Println("error locking login: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func deleteLoginAttempts(db *sql.DB, clientType shared.ClientType, email string) error {
+	query := "DELETE FROM login_attempt_table WHERE client_type = $1 AND email = $2"
+
+	_, err := db.Exec(query, clientType, email)
+	if err != nil {
+		log.Println("error deleting from login_attempt_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func getMFA(db *sql.DB, clientType shared.ClientType, clientID int) (*MFA, error) {
+	query := "SELECT client_type, client_id, secret, enabled, last_used_step FROM mfa_table WHERE client_type = $1 AND client_id = $2"
+
+	mfa := MFA{}
+	err := db.QueryRow(query, clientType, clientID).Scan(&mfa.ClientType, &mfa.ClientID, &mfa.Secret, &mfa.Enabled, &mfa.LastUsedStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		log.Println("error selecting from mfa_table: " + err.Error())
+		return nil, err
+	}
+
+	return &mfa, nil
+}
+
+func upsertMFASecret(db *sql.DB, clientType shared.ClientType, clientID int, secret string) error {
+	query := `INSERT INTO mfa_table (client_type, client_id, secret, enabled, last_used_step, created_at)
+	VALUES ($1, $2, $3, FALSE, 0, NOW())
+	ON CONFLICT (client_type, client_id) DO UPDATE SET secret = $3, enabled = FALSE, last_used_step = 0, created_at = NOW()`
+
+	_, err := db.Exec(query, clientType, clientID, secret)
+	if err != nil {
+		log.Println("error upserting mfa_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// enableMFA enables two factor authentication replacing the recovery codes of the client
+func enableMFA(db *sql.DB, clientType shared.ClientType, clientID int, step int64, recoveryCodeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE mfa_table SET enabled = TRUE, last_used_step = $3 WHERE client_type = $1 AND client_id = $2", clientType, clientID, step)
+	if err != nil {
+		log.Println("error enabling mfa: " + err.Error())
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM mfa_recovery_code_table WHERE client_type = $1 AND client_id = $2", clientType, clientID)
+	if err != nil {
+		log.Println("error deleting from mfa_recovery_code_table: " + err.Error())
+		return err
+	}
+
+	for _, codeHash := range recoveryCodeHashes {
+		_, err = tx.Exec("INSERT INTO mfa_recovery_code_table (client_type, client_id, code_hash) VALUES ($1, $2, $3)", clientType, clientID, codeHash)
+		if err != nil {
+			log.Println("error inserting into mfa_recovery_code_table: " + err.Error())
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// useMFAStep records the time step of an accepted code, it returns false if the step was already used
+func useMFAStep(db *sql.DB, clientType shared.ClientType, clientID int, step int64) (bool, error) {
+	query := "UPDATE mfa_table SET last_used_step = $3 WHERE client_type = $1 AND client_id = $2 AND enabled AND last_used_step < $3"
+
+	result, err := db.Exec(query, clientType, clientID, step)
+	if err != nil {
+		log.Println("error updating mfa_table: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func useRecoveryCode(db *sql.DB, clientType shared.ClientType, clientID int, codeHash string) (bool, error) {
+	query := "UPDATE mfa_recovery_code_table SET used_at = NOW() WHERE client_type = $1 AND client_id = $2 AND code_hash = $3 AND used_at IS NULL"
+
+	result, err := db.Exec(query, clientType, clientID, codeHash)
+	if err != nil {
+		log.Println("error updating mfa_recovery_code_table: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func deleteMFA(db *sql.DB, clientType shared.ClientType, clientID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM mfa_recovery_code_table WHERE client_type = $1 AND client_id = $2", clientType, clientID)
+	if err != nil {
+		log.Println("error deleting from mfa_recovery_code_table: " + err.Error())
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM mfa_table WHERE client_type = $1 AND client_id = $2", clientType, clientID)
+	if err != nil {
+		log.Println("error deleting from mfa_table: " + err.Error())
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetClientSessions returns the sessions stored for the client
+func GetClientSessions(db *sql.DB, clientType shared.ClientType, clientID int) ([]Session, error) {
+	query := "SELECT session_id, created_at, user_id, user_type, device_token FROM sessions WHERE user_id = $1 AND user_type = $2 ORDER BY created_at DESC"
+
+	rows, err := db.Query(query, clientID, clientType)
+	if err != nil {
+		log.Println("error selecting from sessions: " + err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session := Session{}
+		err = rows.Scan(&session.SessionID, &session.CreatedAt, &session.UserID, &session.UserType, &session.Token)
+		if err != nil {
+			log.Println("error scanning sessions: " + err.Error())
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// DeleteAnonymizedClientsData deletes the sessions, tokens, codes, MFA secrets and login attempts of the anonymized
+// clients on the transaction that anonymizes them
+func DeleteAnonymizedClientsData(tx *sql.Tx, clientType shared.ClientType, clients []shared.AnonymizedClient) error {
+	clientIDs := []int64{}
+	emails := []string{}
+	for _, client := range clients {
+		clientIDs = append(clientIDs, int64(client.ClientID))
+		emails = append(emails, normalizeEmail(client.Email))
+	}
+
+	_, err := tx.Exec("DELETE FROM sessions WHERE user_type = $1 AND user_id = ANY($2)", clientType, pq.Array(clientIDs))
+	if err != nil {
+		log.Println("error deleting from sessions: " + err.Error())
+		return err
+	}
+
+	tables := []string{"refresh_token_table", "mfa_recovery_code_table", "mfa_table", "verification_code_table", "password_reset_table"}
+	for _, table := range tables {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE client_type = $1 AND client_id = ANY($2)", clientType, pq.Array(clientIDs))
+		if err != nil {
+			log.Println("error deleting from " + table + ": " + err.Error())
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM login_attempt_table WHERE client_type = $1 AND email = ANY($2)", clientType, pq.Array(emails))
+	if err != nil {
+		log.Println("error deleting from login_attempt_table: " + err.Error())
+		return err
+	}
 
-	return &session, err
+	return nil
 }
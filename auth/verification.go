@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	adm "github.com/CartechAPI/admin"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/sender"
+	"github.com/CartechAPI/shared"
+	us "github.com/CartechAPI/user"
+)
+
+// VerificationChannel is the contact detail being verified
+type VerificationChannel string
+
+const (
+	// VerificationChannelEmail email verification
+	VerificationChannelEmail VerificationChannel = "email"
+	// VerificationChannelPhone phone number verification
+	VerificationChannelPhone VerificationChannel = "phone"
+)
+
+// VerificationPolicy is the contact details a client must verify before using the platform
+type VerificationPolicy string
+
+const (
+	// VerificationPolicyNone nothing has to be verified
+	VerificationPolicyNone VerificationPolicy = "none"
+	// VerificationPolicyEmail the email has to be verified
+	VerificationPolicyEmail VerificationPolicy = "email"
+	// VerificationPolicyPhone the phone number has to be verified
+	VerificationPolicyPhone VerificationPolicy = "phone"
+	// VerificationPolicyAll both email and phone number have to be verified
+	VerificationPolicyAll VerificationPolicy = "all"
+)
+
+var (
+	// ErrInvalidVerificationChannel invalid verification channel
+	ErrInvalidVerificationChannel = shared.NewBadRequestError("invalid verification channel")
+	// ErrInvalidVerificationCode invalid or expired verification code
+	ErrInvalidVerificationCode = shared.NewBadRequestError("invalid or expired code")
+	// ErrUnverifiedContact contact details are not verified
+	ErrUnverifiedContact = shared.NewShowableError("contact details must be verified first", http.StatusForbidden)
+)
+
+const (
+	// verificationCodeDuration is how long a verification code can be used
+	verificationCodeDuration = 24 * time.Hour
+	// maxVerificationAttempts is the number of wrong codes after which the code stops working
+	maxVerificationAttempts = 5
+)
+
+var (
+	// ErrUnknownVerificationPolicy the verification policy is not one of the known ones
+	ErrUnknownVerificationPolicy = errors.New("unknown contact verification policy")
+	// ErrPolicyRequiresSMS the verification policy needs phone numbers verified but the sender can not send sms
+	ErrPolicyRequiresSMS = errors.New("contact verification policy requires a sender that can send sms")
+)
+
+var verificationPolicy = VerificationPolicyNone
+
+// parseVerificationPolicy returns the policy of the value, an empty value means nothing has to be verified
+func parseVerificationPolicy(value string) (VerificationPolicy, error) {
+	policy := VerificationPolicy(value)
+	switch policy {
+	case "":
+		return VerificationPolicyNone, nil
+	case VerificationPolicyNone, VerificationPolicyEmail, VerificationPolicyPhone, VerificationPolicyAll:
+		return policy, nil
+	}
+
+	return "", ErrUnknownVerificationPolicy
+}
+
+// LoadVerificationPolicy sets the policy from CONTACT_VERIFICATION_POLICY, it fails on unknown values so a typo
+// does not turn the verification off, and on policies verifying phone numbers if the sender can not send sms
+func LoadVerificationPolicy(contactSender sender.Sender) error {
+	policy, err := parseVerificationPolicy(os.Getenv("CONTACT_VERIFICATION_POLICY"))
+	if err != nil {
+		return err
+	}
+
+	if policy.requiresPhone() && !contactSender.SupportsSMS() {
+		return ErrPolicyRequiresSMS
+	}
+
+	verificationPolicy = policy
+
+	return nil
+}
+
+// requiresPhone tells if the policy needs the phone number verified
+func (policy VerificationPolicy) requiresPhone() bool {
+	return policy == VerificationPolicyPhone || policy == VerificationPolicyAll
+}
+
+// isSatisfiedBy tells if the verified contact details are enough for the policy
+func (policy VerificationPolicy) isSatisfiedBy(verifiedEmail bool, verifiedPhone bool) bool {
+	switch policy {
+	case VerificationPolicyEmail:
+		return verifiedEmail
+	case VerificationPolicyPhone:
+		return verifiedPhone
+	case VerificationPolicyAll:
+		return verifiedEmail && verifiedPhone
+	case VerificationPolicyNone:
+		return true
+	}
+
+	return false
+}
+
+// contactDetails returns the email, phone number and their verification flags of the client
+func contactDetails(db *sql.DB, clientType shared.ClientType, id int) (email string, phone string, verifiedEmail bool, verifiedPhone bool, err error) {
+	switch clientType {
+	case shared.ClientTypeUser:
+		user, err := us.GetUserByID(db, id)
+		if err != nil {
+			return "", "", false, false, err
+		}
+
+		return user.Email, user.PhoneNumber, user.VerifiedEmail, user.VerifiedPhone, nil
+	case shared.ClientTypeMechanic:
+		mechanic, err := mec.GetMechanicByID(db, id)
+		if err != nil {
+			return "", "", false, false, err
+		}
+
+		return mechanic.Email, mechanic.PhoneNumber, mechanic.VerifiedEmail, mechanic.VerifiedPhone, nil
+	case shared.ClientTypeAdmin:
+		admin, err := adm.GetAdminByID(db, id)
+		if err != nil {
+			return "", "", false, false, err
+		}
+
+		return admin.Email, "", true, true, nil
+	}
+
+	return "", "", true, true, nil
+}
+
+// CheckContactVerified returns ErrUnverifiedContact if the client does not comply with the verification policy
+func CheckContactVerified(db *sql.DB, clientType shared.ClientType, id int) error {
+	if verificationPolicy == VerificationPolicyNone {
+		return nil
+	}
+
+	_, _, verifiedEmail, verifiedPhone, err := contactDetails(db, clientType, id)
+	if err != nil {
+		return err
+	}
+
+	if !verificationPolicy.isSatisfiedBy(verifiedEmail, verifiedPhone) {
+		return ErrUnverifiedContact
+	}
+
+	return nil
+}
+
+func sendVerificationCode(db *sql.DB, contactSender sender.Sender, clientType shared.ClientType, id int, channel VerificationChannel) error {
+	email, phone, verifiedEmail, verifiedPhone, err := contactDetails(db, clientType, id)
+	if err != nil {
+		return err
+	}
+
+	if (channel == VerificationChannelEmail && verifiedEmail) || (channel == VerificationChannelPhone && verifiedPhone) {
+		return nil
+	}
+
+	code, err := generateNumericCode(6)
+	if err != nil {
+		return err
+	}
+
+	err = insertVerificationCode(db, clientType, id, channel, hashToken(code), time.Now().Add(verificationCodeDuration))
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Tu codigo de verificacion de Cartech es %s", code)
+
+	switch channel {
+	case VerificationChannelEmail:
+		return contactSender.SendEmail(email, "Verifica tu correo", body)
+	case VerificationChannelPhone:
+		return contactSender.SendSMS(phone, body)
+	}
+
+	return ErrInvalidVerificationChannel
+}
+
+// sendSignUpVerificationCodes sends the codes for both channels, failures are logged so the sign up still succeeds
+func sendSignUpVerificationCodes(db *sql.DB, contactSender sender.Sender, clientType shared.ClientType, id int) {
+	for _, channel := range []VerificationChannel{VerificationChannelEmail, VerificationChannelPhone} {
+		err := sendVerificationCode(db, contactSender, clientType, id, channel)
+		if err != nil {
+			log.Println("error_sending_verification_code: " + err.Error())
+		}
+	}
+}
+
+func isVerificationChannelValid(channel VerificationChannel) bool {
+	return channel == VerificationChannelEmail || channel == VerificationChannelPhone
+}
+
+func setContactVerified(db *sql.DB, clientType shared.ClientType, id int, channel VerificationChannel) error {
+	switch {
+	case clientType == shared.ClientTypeUser && channel == VerificationChannelEmail:
+		return us.SetUserEmailVerified(db, id)
+	case clientType == shared.ClientTypeUser && channel == VerificationChannelPhone:
+		return us.SetUserPhoneVerified(db, id)
+	case clientType == shared.ClientTypeMechanic && channel == VerificationChannelEmail:
+		return mec.SetMechanicEmailVerified(db, id)
+	case clientType == shared.ClientTypeMechanic && channel == VerificationChannelPhone:
+		return mec.SetMechanicPhoneVerified(db, id)
+	}
+
+	return ErrInvalidVerificationChannel
+}
+
+func verifyContact(db *sql.DB, clientType shared.ClientType, id int, channel VerificationChannel, code string) error {
+	if !isVerificationChannelValid(channel) {
+		return ErrInvalidVerificationChannel
+	}
+
+	if code == "" {
+		return ErrMissingCode
+	}
+
+	used, err := useVerificationCode(db, clientType, id, channel, hashToken(code))
+	if err != nil {
+		return err
+	}
+
+	if !used {
+		err = registerFailedVerificationAttempt(db, clientType, id, channel)
+		if err != nil {
+			return err
+		}
+
+		return ErrInvalidVerificationCode
+	}
+
+	return setContactVerified(db, clientType, id, channel)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/CartechAPI/assigner"
+	"github.com/CartechAPI/notifications"
+	"github.com/CartechAPI/order"
+	"github.com/CartechAPI/queue"
+	_ "github.com/lib/pq"
+	"github.com/streadway/amqp"
+	"github.com/subosito/gotenv"
+)
+
+func init() {
+	gotenv.Load()
+}
+
+func main() {
+	connectionString := os.Getenv("DB_CONNECTION")
+	db, err := sql.Open("postgres", connectionString)
+	if err != nil {
+		log.Fatal("could_not_open_db: ", err)
+	}
+
+	defer db.Close()
+
+	conn, err := amqp.Dial(os.Getenv("CLOUDAMQP_URL"))
+	failOnError(err, "Failed to connect to RabbitMQ")
+	defer conn.Close()
+
+	channel, err := conn.Channel()
+	failOnError(err, "Failed to open a channel")
+	defer channel.Close()
+
+	err = order.AssignerTopology.Declare(channel)
+	failOnError(err, "Failed to declare the queues")
+
+	notifier, err := notifications.FromEnv()
+	failOnError(err, "Failed to configure the notifications")
+
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+
+	// the retries are published in confirm mode on their own channel, like the messages of the api
+	publishChannel, err := conn.Channel()
+	failOnError(err, "Failed to open a channel")
+	defer publishChannel.Close()
+
+	publisher, err := queue.NewAMQPPublisher(publishChannel)
+	failOnError(err, "Failed to put the channel in confirm mode")
+
+	consumer := queue.NewAMQPConsumer(channel, publisher, order.AssignerTopology, assigner.ConsumerName)
+	err = assigner.Run(db, consumer, notifier, stop)
+	failOnError(err, "Failed to consume the queue")
+}
+
+func failOnError(err error, msg string) {
+	if err != nil {
+		log.Fatalf("%s: %s", msg, err)
+	}
+}
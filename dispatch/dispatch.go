@@ -0,0 +1,125 @@
+package dispatch
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/CartechAPI/mechanic"
+)
+
+const earthRadiusKm = 6371.0
+
+// DefaultBatchSize is how many mechanics are offered an order at the same time
+const DefaultBatchSize = 3
+
+// OfferTimeout is how long a mechanic has to answer an offer
+const OfferTimeout = 45 * time.Second
+
+// Stage is a step of the dispatch, mechanics within the radius are offered the order during
+// the wait and the next stage starts if none of them took it
+type Stage struct {
+	RadiusKm float64
+	Wait     time.Duration
+}
+
+// DefaultStages widens the search radius the longer an order stays without a mechanic
+var DefaultStages = []Stage{
+	{RadiusKm: 5, Wait: time.Minute},
+	{RadiusKm: 10, Wait: 2 * time.Minute},
+	{RadiusKm: 25, Wait: 3 * time.Minute},
+	{RadiusKm: 50, Wait: 5 * time.Minute},
+}
+
+// RadiusAt returns the search radius for an order that has been waiting for the elapsed time,
+// it returns false once the order outlived every stage
+func RadiusAt(stages []Stage, elapsed time.Duration) (float64, bool) {
+	var end time.Duration
+	for _, stage := range stages {
+		end += stage.Wait
+		if elapsed < end {
+			return stage.RadiusKm, true
+		}
+	}
+
+	return 0, false
+}
+
+// Duration returns how long the dispatch lasts before giving up on an order
+func Duration(stages []Stage) time.Duration {
+	var total time.Duration
+	for _, stage := range stages {
+		total += stage.Wait
+	}
+
+	return total
+}
+
+// averageSpeedKmh is the speed used to estimate how long a mechanic takes to get to an order
+const averageSpeedKmh = 30.0
+
+// EstimateArrival returns the minutes a mechanic takes to travel the distance, at least one
+func EstimateArrival(distanceKm float64) int {
+	minutes := int(math.Ceil(distanceKm / averageSpeedKmh * 60))
+	if minutes < 1 {
+		return 1
+	}
+
+	return minutes
+}
+
+// Candidate is a mechanic that may be offered an order
+type Candidate struct {
+	MechanicID int     `json:"mechanic_id"`
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// Distance returns the great circle distance in kilometers between two points using the haversine formula
+func Distance(lat1 float64, lng1 float64, lat2 float64, lng2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// Rank returns the mechanics ordered by their distance to the given point, closest first
+func Rank(lat float64, lng float64, locations []mechanic.Location) []Candidate {
+	candidates := make([]Candidate, 0, len(locations))
+	for _, location := range locations {
+		candidates = append(candidates, Candidate{
+			MechanicID: location.MechanicID,
+			DistanceKm: Distance(lat, lng, location.Lat, location.Lng),
+		})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].DistanceKm < candidates[j].DistanceKm
+	})
+
+	return candidates
+}
+
+// NextBatch returns up to size ranked candidates within the radius that were not offered the order yet
+func NextBatch(candidates []Candidate, radiusKm float64, offered map[int]bool, size int) []Candidate {
+	batch := []Candidate{}
+	for _, candidate := range candidates {
+		if len(batch) == size || candidate.DistanceKm > radiusKm {
+			break
+		}
+
+		if offered[candidate.MechanicID] {
+			continue
+		}
+
+		batch = append(batch, candidate)
+	}
+
+	return batch
+}
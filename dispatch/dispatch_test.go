@@ -0,0 +1,83 @@
+package dispatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CartechAPI/mechanic"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistance(t *testing.T) {
+	c := require.New(t)
+
+	c.Equal(0.0, Distance(18.4861, -69.9312, 18.4861, -69.9312))
+	// Santo Domingo to Santiago de los Caballeros
+	c.InDelta(134.0, Distance(18.4861, -69.9312, 19.4517, -70.6970), 2)
+}
+
+func TestRank(t *testing.T) {
+	c := require.New(t)
+
+	locations := []mechanic.Location{
+		{MechanicID: 1, Lat: 18.60, Lng: -69.93},
+		{MechanicID: 2, Lat: 18.49, Lng: -69.93},
+		{MechanicID: 3, Lat: 18.52, Lng: -69.93},
+	}
+
+	candidates := Rank(18.4861, -69.9312, locations)
+	c.Len(candidates, 3)
+	c.Equal(2, candidates[0].MechanicID)
+	c.Equal(3, candidates[1].MechanicID)
+	c.Equal(1, candidates[2].MechanicID)
+}
+
+func TestNextBatch(t *testing.T) {
+	c := require.New(t)
+
+	candidates := []Candidate{
+		{MechanicID: 1, DistanceKm: 1},
+		{MechanicID: 2, DistanceKm: 2},
+		{MechanicID: 3, DistanceKm: 4},
+		{MechanicID: 4, DistanceKm: 8},
+	}
+
+	batch := NextBatch(candidates, 5, map[int]bool{}, 2)
+	c.Equal([]Candidate{candidates[0], candidates[1]}, batch)
+
+	batch = NextBatch(candidates, 5, map[int]bool{1: true, 2: true}, 2)
+	c.Equal([]Candidate{candidates[2]}, batch)
+
+	batch = NextBatch(candidates, 10, map[int]bool{1: true, 2: true, 3: true}, 2)
+	c.Equal([]Candidate{candidates[3]}, batch)
+}
+
+func TestRadiusAt(t *testing.T) {
+	c := require.New(t)
+
+	stages := []Stage{
+		{RadiusKm: 5, Wait: time.Minute},
+		{RadiusKm: 10, Wait: 2 * time.Minute},
+	}
+
+	radius, ok := RadiusAt(stages, 0)
+	c.True(ok)
+	c.Equal(5.0, radius)
+
+	radius, ok = RadiusAt(stages, 90*time.Second)
+	c.True(ok)
+	c.Equal(10.0, radius)
+
+	_, ok = RadiusAt(stages, 3*time.Minute)
+	c.False(ok)
+
+	c.Equal(3*time.Minute, Duration(stages))
+}
+
+func TestEstimateArrival(t *testing.T) {
+	c := require.New(t)
+
+	c.Equal(1, EstimateArrival(0))
+	c.Equal(10, EstimateArrival(5))
+	c.Equal(11, EstimateArrival(5.1))
+}
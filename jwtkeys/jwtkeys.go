@@ -0,0 +1,343 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+var (
+	// ErrUnknownKeyID the token was signed with a key that is not in the keyset
+	ErrUnknownKeyID = errors.New("unknown key id")
+	// ErrInvalidSigningMethod the token algorithm does not match the key
+	ErrInvalidSigningMethod = errors.New("invalid token signing method")
+	// ErrNoSigningKey the active key can not sign tokens
+	ErrNoSigningKey = errors.New("active key can not sign tokens")
+	// ErrUnsupportedAlgorithm the key algorithm is not supported
+	ErrUnsupportedAlgorithm = errors.New("unsupported key algorithm")
+	// ErrInvalidPEM the key is not PEM encoded
+	ErrInvalidPEM = errors.New("invalid PEM key")
+	// ErrMissingKeyID the key has no id
+	ErrMissingKeyID = errors.New("missing key id")
+	// ErrDuplicateKeyID two keys of the set have the same id
+	ErrDuplicateKeyID = errors.New("duplicate key id")
+	// ErrWeakSecret the HS256 secret is too short to be safe
+	ErrWeakSecret = errors.New("HS256 secret is too short")
+	// ErrMissingAlgorithm the key has PEM material but no alg, it would be taken as an HS256 secret
+	ErrMissingAlgorithm = errors.New("missing key algorithm")
+)
+
+// legacyKeyID identifies the key taken from SECRET, used for tokens issued without kid
+const legacyKeyID = "default"
+
+// minHMACSecretLength is the minimum length of an HS256 secret, as long as the output of the hash
+const minHMACSecretLength = 32
+
+// Key is a key used to sign or verify tokens
+type Key struct {
+	ID              string
+	Method          jwt.SigningMethod
+	signingKey      interface{}
+	verificationKey interface{}
+	// legacy tells the key was taken from SECRET
+	legacy bool
+}
+
+// CanSign tells if the key holds private material
+func (k Key) CanSign() bool {
+	return k.signingKey != nil
+}
+
+// NewHMACKey returns a HS256 key
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{ID: id, Method: jwt.SigningMethodHS256, signingKey: secret, verificationKey: secret}
+}
+
+// NewRSAKey returns a RS256 key, the private key is nil for verification only keys
+func NewRSAKey(id string, privateKey *rsa.PrivateKey, publicKey *rsa.PublicKey) Key {
+	key := Key{ID: id, Method: jwt.SigningMethodRS256, verificationKey: publicKey}
+	if privateKey != nil {
+		key.signingKey = privateKey
+	}
+
+	return key
+}
+
+// NewEd25519Key returns an EdDSA key, the private key is nil for verification only keys
+func NewEd25519Key(id string, privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) Key {
+	key := Key{ID: id, Method: SigningMethodEdDSA, verificationKey: publicKey}
+	if privateKey != nil {
+		key.signingKey = privateKey
+	}
+
+	return key
+}
+
+// KeySet holds the key used to sign new tokens and the keys accepted when verifying them
+type KeySet struct {
+	activeKeyID string
+	keys        map[string]Key
+}
+
+// newLegacyKey returns the HS256 key taken from SECRET
+func newLegacyKey(secret []byte) Key {
+	key := NewHMACKey(legacyKeyID, secret)
+	key.legacy = true
+
+	return key
+}
+
+// validateKey checks the key has an id and, for HS256 keys, a secret long enough to not be guessed.
+// A short SECRET is only warned about, refusing it would log out every client of the deployments still using it
+func validateKey(key Key) error {
+	if key.ID == "" {
+		return ErrMissingKeyID
+	}
+
+	if secret, ok := key.verificationKey.([]byte); ok && len(secret) < minHMACSecretLength {
+		if !key.legacy {
+			return ErrWeakSecret
+		}
+
+		log.Println("warning: SECRET is too short to be safe, rotate to a key of at least 32 bytes on JWT_KEYS")
+	}
+
+	return nil
+}
+
+// NewKeySet returns a keyset signing with the key of the given id, the ids of the keys must be unique
+func NewKeySet(activeKeyID string, keys ...Key) (*KeySet, error) {
+	keySet := &KeySet{activeKeyID: activeKeyID, keys: map[string]Key{}}
+	for _, key := range keys {
+		err := validateKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := keySet.keys[key.ID]; ok {
+			return nil, ErrDuplicateKeyID
+		}
+
+		keySet.keys[key.ID] = key
+	}
+
+	active, ok := keySet.keys[activeKeyID]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	if !active.CanSign() {
+		return nil, ErrNoSigningKey
+	}
+
+	return keySet, nil
+}
+
+// Sign signs the claims with the active key and sets its id as the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := ks.keys[ks.activeKeyID]
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.signingKey)
+}
+
+// Keyfunc returns the verification key for the token, to be used with jwt.Parse
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	keyID, _ := token.Header["kid"].(string)
+	if keyID == "" {
+		keyID = legacyKeyID
+	}
+
+	key, ok := ks.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+
+	// the algorithm must be the one of the key, otherwise a public key could be used as an HMAC secret
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, ErrInvalidSigningMethod
+	}
+
+	return key.verificationKey, nil
+}
+
+// JWK is the public representation of a key as described on RFC 7517
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// PublicKeys returns the asymmetric keys of the set so other services can verify tokens
+func (ks *KeySet) PublicKeys() []JWK {
+	jwks := []JWK{}
+	for _, key := range ks.keys {
+		switch publicKey := key.verificationKey.(type) {
+		case *rsa.PublicKey:
+			jwks = append(jwks, JWK{
+				KeyType:   "RSA",
+				KeyID:     key.ID,
+				Algorithm: key.Method.Alg(),
+				Use:       "sig",
+				N:         base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks = append(jwks, JWK{
+				KeyType:   "OKP",
+				KeyID:     key.ID,
+				Algorithm: key.Method.Alg(),
+				Use:       "sig",
+				Curve:     "Ed25519",
+				X:         base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+
+	return jwks
+}
+
+// keyConfig is the configuration of a key on JWT_KEYS
+type keyConfig struct {
+	ID         string `json:"kid"`
+	Algorithm  string `json:"alg"`
+	Secret     string `json:"secret"`
+	PrivateKey string `json:"private_key"`
+	PublicKey  string `json:"public_key"`
+}
+
+func (config keyConfig) toKey() (Key, error) {
+	// a key with PEM material that forgot its alg must not become an HMAC key with an empty secret
+	if config.Algorithm == "" && (config.PrivateKey != "" || config.PublicKey != "") {
+		return Key{}, ErrMissingAlgorithm
+	}
+
+	switch config.Algorithm {
+	case "", jwt.SigningMethodHS256.Alg():
+		return NewHMACKey(config.ID, []byte(config.Secret)), nil
+	case jwt.SigningMethodRS256.Alg():
+		var privateKey *rsa.PrivateKey
+		var err error
+		if config.PrivateKey != "" {
+			privateKey, err = jwt.ParseRSAPrivateKeyFromPEM([]byte(config.PrivateKey))
+			if err != nil {
+				return Key{}, err
+			}
+		}
+
+		if config.PublicKey == "" && privateKey != nil {
+			return NewRSAKey(config.ID, privateKey, &privateKey.PublicKey), nil
+		}
+
+		publicKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(config.PublicKey))
+		if err != nil {
+			return Key{}, err
+		}
+
+		return NewRSAKey(config.ID, privateKey, publicKey), nil
+	case SigningMethodEdDSA.Alg():
+		var privateKey ed25519.PrivateKey
+		if config.PrivateKey != "" {
+			parsed, err := parsePEM(config.PrivateKey, x509.ParsePKCS8PrivateKey)
+			if err != nil {
+				return Key{}, err
+			}
+
+			if privateKey, _ = parsed.(ed25519.PrivateKey); privateKey == nil {
+				return Key{}, ErrInvalidEd25519Key
+			}
+		}
+
+		if config.PublicKey == "" && privateKey != nil {
+			return NewEd25519Key(config.ID, privateKey, privateKey.Public().(ed25519.PublicKey)), nil
+		}
+
+		parsed, err := parsePEM(config.PublicKey, x509.ParsePKIXPublicKey)
+		if err != nil {
+			return Key{}, err
+		}
+
+		publicKey, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return Key{}, ErrInvalidEd25519Key
+		}
+
+		return NewEd25519Key(config.ID, privateKey, publicKey), nil
+	}
+
+	return Key{}, ErrUnsupportedAlgorithm
+}
+
+func parsePEM(encoded string, parse func([]byte) (interface{}, error)) (interface{}, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, ErrInvalidPEM
+	}
+
+	return parse(block.Bytes)
+}
+
+// FromEnv loads the keyset from JWT_KEYS, a json list of keys, signing with the key JWT_ACTIVE_KID.
+// SECRET is still accepted as an HS256 key for tokens without kid and as the active key if no other is set
+func FromEnv() (*KeySet, error) {
+	keys := []Key{}
+	activeKeyID := os.Getenv("JWT_ACTIVE_KID")
+
+	if secret := os.Getenv("SECRET"); secret != "" {
+		keys = append(keys, newLegacyKey([]byte(secret)))
+		if activeKeyID == "" {
+			activeKeyID = legacyKeyID
+		}
+	}
+
+	if rawKeys := os.Getenv("JWT_KEYS"); rawKeys != "" {
+		configs := []keyConfig{}
+		err := json.Unmarshal([]byte(rawKeys), &configs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, config := range configs {
+			key, err := config.toKey()
+			if err != nil {
+				return nil, err
+			}
+
+			keys = append(keys, key)
+		}
+	}
+
+	return NewKeySet(activeKeyID, keys...)
+}
+
+var (
+	defaultKeySet *KeySet
+	defaultErr    error
+	loadOnce      sync.Once
+)
+
+// Default returns the keyset loaded from the environment, it is only loaded once
+func Default() (*KeySet, error) {
+	loadOnce.Do(func() {
+		defaultKeySet, defaultErr = FromEnv()
+	})
+
+	return defaultKeySet, defaultErr
+}
@@ -0,0 +1,172 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oldSecret = "old-secret-that-is-at-least-32-bytes"
+	newSecret = "new-secret-that-is-at-least-32-bytes"
+)
+
+func TestKeySetRotation(t *testing.T) {
+	c := require.New(t)
+
+	oldKeySet, err := NewKeySet("old", NewHMACKey("old", []byte(oldSecret)))
+	c.Nil(err)
+
+	oldToken, err := oldKeySet.Sign(jwt.MapClaims{"id": 1})
+	c.Nil(err)
+
+	newKeySet, err := NewKeySet("new", NewHMACKey("old", []byte(oldSecret)), NewHMACKey("new", []byte(newSecret)))
+	c.Nil(err)
+
+	token, err := jwt.Parse(oldToken, newKeySet.Keyfunc)
+	c.Nil(err)
+	c.True(token.Valid)
+
+	newToken, err := newKeySet.Sign(jwt.MapClaims{"id": 1})
+	c.Nil(err)
+
+	token, err = jwt.Parse(newToken, newKeySet.Keyfunc)
+	c.Nil(err)
+	c.Equal("new", token.Header["kid"])
+
+	_, err = jwt.Parse(newToken, oldKeySet.Keyfunc)
+	c.NotNil(err)
+}
+
+func TestKeySetAsymmetricKeys(t *testing.T) {
+	c := require.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Nil(err)
+
+	edPublicKey, edPrivateKey, err := ed25519.GenerateKey(rand.Reader)
+	c.Nil(err)
+
+	for _, activeKeyID := range []string{"rsa", "ed"} {
+		keySet, err := NewKeySet(activeKeyID,
+			NewRSAKey("rsa", rsaKey, &rsaKey.PublicKey),
+			NewEd25519Key("ed", edPrivateKey, edPublicKey),
+		)
+		c.Nil(err)
+
+		signed, err := keySet.Sign(jwt.MapClaims{"id": 1})
+		c.Nil(err)
+
+		token, err := jwt.Parse(signed, keySet.Keyfunc)
+		c.Nil(err)
+		c.True(token.Valid)
+
+		c.Len(keySet.PublicKeys(), 2)
+	}
+}
+
+func TestKeySetRejectsAlgorithmMismatch(t *testing.T) {
+	c := require.New(t)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	c.Nil(err)
+
+	keySet, err := NewKeySet("rsa", NewRSAKey("rsa", rsaKey, &rsaKey.PublicKey))
+	c.Nil(err)
+
+	// a token signed with HS256 claiming the rsa key id must not be accepted
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1})
+	token.Header["kid"] = "rsa"
+	signed, err := token.SignedString([]byte("whatever"))
+	c.Nil(err)
+
+	_, err = jwt.Parse(signed, keySet.Keyfunc)
+	c.NotNil(err)
+}
+
+func TestNewKeySetRequiresSigningKey(t *testing.T) {
+	c := require.New(t)
+
+	edPublicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	c.Nil(err)
+
+	_, err = NewKeySet("ed", NewEd25519Key("ed", nil, edPublicKey))
+	c.Equal(ErrNoSigningKey, err)
+
+	_, err = NewKeySet("missing", NewHMACKey("other", []byte(oldSecret)))
+	c.Equal(ErrUnknownKeyID, err)
+}
+
+func TestNewKeySetRejectsInvalidKeys(t *testing.T) {
+	c := require.New(t)
+
+	_, err := NewKeySet("", NewHMACKey("", []byte(oldSecret)))
+	c.Equal(ErrMissingKeyID, err)
+
+	_, err = NewKeySet("short", NewHMACKey("short", []byte("secret")))
+	c.Equal(ErrWeakSecret, err)
+
+	_, err = NewKeySet("empty", NewHMACKey("empty", nil))
+	c.Equal(ErrWeakSecret, err)
+
+	_, err = NewKeySet("old", NewHMACKey("old", []byte(oldSecret)), NewHMACKey("old", []byte(newSecret)))
+	c.Equal(ErrDuplicateKeyID, err)
+}
+
+func setEnv(t *testing.T, key, value string) {
+	previous, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestFromEnvAcceptsShortLegacySecret(t *testing.T) {
+	c := require.New(t)
+
+	setEnv(t, "SECRET", "short")
+	setEnv(t, "JWT_KEYS", "")
+	setEnv(t, "JWT_ACTIVE_KID", "")
+
+	keySet, err := FromEnv()
+	c.Nil(err)
+
+	signed, err := keySet.Sign(jwt.MapClaims{"id": 1})
+	c.Nil(err)
+
+	token, err := jwt.Parse(signed, keySet.Keyfunc)
+	c.Nil(err)
+	c.True(token.Valid)
+
+	// tokens issued before the keyset existed have no kid
+	legacyToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": 1}).SignedString([]byte("short"))
+	c.Nil(err)
+
+	token, err = jwt.Parse(legacyToken, keySet.Keyfunc)
+	c.Nil(err)
+	c.True(token.Valid)
+
+	setEnv(t, "JWT_KEYS", `[{"kid": "short", "secret": "short"}]`)
+	_, err = FromEnv()
+	c.Equal(ErrWeakSecret, err)
+}
+
+func TestKeyConfigRequiresAlgorithmForPEM(t *testing.T) {
+	c := require.New(t)
+
+	_, err := keyConfig{ID: "rsa", PublicKey: "-----BEGIN PUBLIC KEY-----"}.toKey()
+	c.Equal(ErrMissingAlgorithm, err)
+
+	key, err := keyConfig{ID: "hmac", Secret: oldSecret}.toKey()
+	c.Nil(err)
+	c.Equal(jwt.SigningMethodHS256, key.Method)
+}
@@ -7,9 +7,18 @@ import (
 	"os"
 	"time"
 
+	"github.com/CartechAPI/admin"
+	"github.com/CartechAPI/assigner"
 	"github.com/CartechAPI/auth"
+	"github.com/CartechAPI/jwtkeys"
+	"github.com/CartechAPI/notifications"
 	"github.com/CartechAPI/order"
+	"github.com/CartechAPI/outbox"
+	"github.com/CartechAPI/profile"
+	"github.com/CartechAPI/queue"
+	"github.com/CartechAPI/sender"
 	"github.com/CartechAPI/service"
+	"github.com/CartechAPI/shared"
 	"github.com/didip/tollbooth"
 	"github.com/didip/tollbooth/limiter"
 	"github.com/gorilla/handlers"
@@ -27,6 +36,12 @@ var (
 	loginLimiter = tollbooth.NewLimiter(0.4, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
 )
 
+// outboxRelayInterval is how often the pending messages of the outbox are published
+const outboxRelayInterval = time.Second
+
+// deferredDeliveryInterval is how often the notifications held during quiet hours are checked
+const deferredDeliveryInterval = time.Minute
+
 var port string
 
 func init() {
@@ -42,22 +57,41 @@ func main() {
 	}
 	defer db.Close()
 
-	queueConnection, err := amqp.Dial(os.Getenv("CLOUDAMQP_URL"))
+	notifier, err := notifications.FromEnv()
 	if err != nil {
-		log.Fatal("could_not_connect_to_queue: ", err)
+		log.Fatal("could_not_configure_notifications: ", err)
 	}
-	defer queueConnection.Close()
 
-	channel, err := queueConnection.Channel()
+	publisher, closeQueue := connectQueue(db, notifier)
+	defer closeQueue()
+
+	go outbox.RunRelay(db, publisher, outboxRelayInterval, nil)
+	go notifications.RunDeferredDelivery(db, notifier, deferredDeliveryInterval, nil)
+
+	_, err = jwtkeys.Default()
 	if err != nil {
-		log.Fatal("could_not_open_channel_to_queue: ", err)
+		log.Fatal("could_not_load_jwt_keys: ", err)
 	}
-	defer channel.Close()
 
 	configureLimiters()
 
+	go profile.RunDeletionJob(db, time.Hour)
+
+	contactSender, err := sender.FromEnv()
+	if err != nil {
+		log.Fatal("could_not_configure_sender: ", err)
+	}
+
+	err = auth.LoadVerificationPolicy(contactSender)
+	if err != nil {
+		log.Fatal("could_not_load_verification_policy: ", err)
+	}
+
+	orderEvents := order.NewEventDispatcher()
+	order.RegisterNotificationHandlers(orderEvents, db, notifier)
+
 	router := mux.NewRouter()
-	defineRoutes(db, channel, router)
+	defineRoutes(db, contactSender, orderEvents, router)
 
 	loggedRouter := handlers.LoggingHandler(os.Stdout, router)
 
@@ -71,25 +105,111 @@ func configureLimiters() {
 	loginLimiter.SetIPLookups([]string{"RemoteAddr", "X-Forwarded-For", "X-Real-IP"})
 }
 
-func defineRoutes(db *sql.DB, channel *amqp.Channel, router *mux.Router) {
+// connectQueue returns the publisher used by the outbox relay. With QUEUE_DRIVER=memory no broker is needed,
+// the messages stay in process and the assigner runs in this same binary, which is meant for local runs and tests
+func connectQueue(db *sql.DB, notifier notifications.Notifier) (queue.Publisher, func()) {
+	if os.Getenv("QUEUE_DRIVER") == "memory" {
+		broker := queue.NewMemoryBroker()
+		go func() {
+			err := assigner.Run(db, broker.NewConsumer(order.AssignerTopology), notifier, nil)
+			if err != nil {
+				log.Fatal("assigner_stopped: ", err)
+			}
+		}()
+
+		return broker, func() {}
+	}
+
+	queueConnection, err := amqp.Dial(os.Getenv("CLOUDAMQP_URL"))
+	if err != nil {
+		log.Fatal("could_not_connect_to_queue: ", err)
+	}
+
+	channel, err := queueConnection.Channel()
+	if err != nil {
+		log.Fatal("could_not_open_channel_to_queue: ", err)
+	}
+
+	err = order.AssignerTopology.Declare(channel)
+	if err != nil {
+		log.Fatal("could_not_declare_queues: ", err)
+	}
+
+	publisher, err := queue.NewAMQPPublisher(channel)
+	if err != nil {
+		log.Fatal("could_not_open_publisher: ", err)
+	}
+
+	return publisher, func() {
+		channel.Close()
+		queueConnection.Close()
+	}
+}
+
+func defineRoutes(db *sql.DB, contactSender sender.Sender, orderEvents *order.EventDispatcher, router *mux.Router) {
 	router.HandleFunc("/", auth.Index()).Methods(http.MethodGet)
+	router.HandleFunc("/.well-known/jwks.json", auth.JWKS()).Methods(http.MethodGet)
 
-	router.Handle("/login", tollbooth.LimitHandler(loginLimiter, auth.Login(db))).Methods(http.MethodPost)
-	router.HandleFunc("/signup", auth.SignUp(db)).Methods(http.MethodPost)
+	router.Handle("/login", tollbooth.LimitHandler(loginLimiter, auth.Login(db, contactSender))).Methods(http.MethodPost)
+	router.HandleFunc("/signup", auth.SignUp(db, contactSender)).Methods(http.MethodPost)
+	router.HandleFunc("/verify", auth.Verify(db)).Methods(http.MethodPost)
+	router.Handle("/verify/resend", tollbooth.LimitHandler(loginLimiter, auth.ResendVerificationCode(db, contactSender))).Methods(http.MethodPost)
 	router.Handle("/session", auth.StoreSession(db)).Methods(http.MethodPost)
-
-	router.Handle("/mechanic/signup", tollbooth.LimitHandler(defaultLimiter, auth.MechanichSignUp(db))).Methods(http.MethodPost)
-	router.HandleFunc("/mechanic/login", auth.MechanicLogin(db)).Methods(http.MethodPost)
+	router.HandleFunc("/session", auth.GetSessions(db)).Methods(http.MethodGet)
+	router.HandleFunc("/session/{session_id}", auth.GetSession(db)).Methods(http.MethodGet)
+	router.HandleFunc("/session/{session_id}", auth.DeleteSession(db)).Methods(http.MethodDelete)
+	router.HandleFunc("/logout", auth.Logout(db)).Methods(http.MethodPost)
+	router.HandleFunc("/logout/all", auth.LogoutAll(db)).Methods(http.MethodPost)
+	router.HandleFunc("/me", profile.GetProfile(db)).Methods(http.MethodGet)
+	router.HandleFunc("/me", profile.UpdateProfile(db)).Methods(http.MethodPatch)
+	router.HandleFunc("/me", profile.DeleteAccount(db)).Methods(http.MethodDelete)
+	router.HandleFunc("/me/restore", profile.RestoreAccount(db)).Methods(http.MethodPost)
+	router.HandleFunc("/me/export", profile.ExportData(db)).Methods(http.MethodGet)
+	router.HandleFunc("/me/password", profile.ChangePassword(db)).Methods(http.MethodPut)
+	router.HandleFunc("/me/notification-preferences", notifications.GetNotificationPreferences(db)).Methods(http.MethodGet)
+	router.HandleFunc("/me/notification-preferences", notifications.UpdateNotificationPreferences(db)).Methods(http.MethodPut)
+	router.Handle("/token/refresh", tollbooth.LimitHandler(defaultLimiter, auth.RefreshAccessToken(db))).Methods(http.MethodPost)
+
+	router.Handle("/mechanic/signup", tollbooth.LimitHandler(defaultLimiter, auth.MechanichSignUp(db, contactSender))).Methods(http.MethodPost)
+	router.Handle("/mechanic/login", tollbooth.LimitHandler(loginLimiter, auth.MechanicLogin(db, contactSender))).Methods(http.MethodPost)
+	router.HandleFunc("/mechanic/me/location", profile.UpdateLocation(db)).Methods(http.MethodPut)
+	router.HandleFunc("/mechanic/me/availability", profile.UpdateAvailability(db)).Methods(http.MethodPut)
+	router.HandleFunc("/mechanic/me/services", profile.GetServices(db)).Methods(http.MethodGet)
+	router.HandleFunc("/mechanic/me/services", profile.UpdateServices(db)).Methods(http.MethodPut)
+
+	router.Handle("/password/forgot", tollbooth.LimitHandler(loginLimiter, auth.ForgotPassword(db, contactSender, shared.ClientTypeUser))).Methods(http.MethodPost)
+	router.Handle("/password/reset", tollbooth.LimitHandler(loginLimiter, auth.ResetPassword(db, shared.ClientTypeUser))).Methods(http.MethodPost)
+	router.Handle("/mechanic/password/forgot", tollbooth.LimitHandler(loginLimiter, auth.ForgotPassword(db, contactSender, shared.ClientTypeMechanic))).Methods(http.MethodPost)
+	router.Handle("/mechanic/password/reset", tollbooth.LimitHandler(loginLimiter, auth.ResetPassword(db, shared.ClientTypeMechanic))).Methods(http.MethodPost)
 
 	router.HandleFunc("/service", service.GetAllServices(db)).Methods(http.MethodGet)
 	router.HandleFunc("/service/category", service.GetAllServiceCategories(db)).Methods(http.MethodGet)
 	router.HandleFunc("/service/category/{category_id}", service.GetServicesByCategoryID(db)).Methods(http.MethodGet)
 
-	router.HandleFunc("/order", order.CreateServiceOrder(db, channel)).Methods(http.MethodPost)
+	router.HandleFunc("/order", order.CreateServiceOrder(db)).Methods(http.MethodPost)
 	router.HandleFunc("/order", order.GetAllServiceOrders(db)).Methods(http.MethodGet)
 	router.Handle("/order/past", order.GetAllPastServiceOrders(db)).Methods(http.MethodGet)
 	router.Handle("/order/current", order.GetAllCurrentOrders(db)).Methods(http.MethodGet)
-	router.HandleFunc("/order/{order_id}", order.UpdateServiceOrder(db)).Methods(http.MethodPatch)
+	router.HandleFunc("/order/{order_id}", order.UpdateServiceOrder(db, orderEvents)).Methods(http.MethodPatch)
 	router.HandleFunc("/order/{order_id}", order.GetServiceOrder(db)).Methods(http.MethodGet)
-	router.HandleFunc("/order/{order_id}/mechanic", order.AssignMechanicToOrder(db)).Methods(http.MethodPut)
+	router.HandleFunc("/order/{order_id}/mechanic", order.AssignMechanicToOrder(db, orderEvents)).Methods(http.MethodPut)
+	router.HandleFunc("/order/{order_id}/events", order.GetServiceOrderEvents(db)).Methods(http.MethodGet)
+
+	router.HandleFunc("/offer", order.GetOffers(db)).Methods(http.MethodGet)
+	router.HandleFunc("/offer/{offer_id}/accept", order.AcceptOffer(db, orderEvents)).Methods(http.MethodPost)
+	router.HandleFunc("/offer/{offer_id}/decline", order.DeclineOffer(db)).Methods(http.MethodPost)
+
+	mfaRouter := router.PathPrefix("/mfa").Subrouter()
+	mfaRouter.Handle("/verify", tollbooth.LimitHandler(loginLimiter, auth.VerifyMFA(db, contactSender))).Methods(http.MethodPost)
+	mfaRouter.Handle("/enroll", auth.RequireClientType(db, shared.ClientTypeMechanic, shared.ClientTypeAdmin)(auth.EnrollMFA(db))).Methods(http.MethodPost)
+	mfaRouter.Handle("/enroll/confirm", auth.RequireClientType(db, shared.ClientTypeMechanic, shared.ClientTypeAdmin)(auth.ConfirmMFA(db))).Methods(http.MethodPost)
+	mfaRouter.Handle("/disable", auth.RequireClientType(db, shared.ClientTypeMechanic, shared.ClientTypeAdmin)(auth.DisableMFA(db))).Methods(http.MethodPost)
+
+	router.Handle("/admin/login", tollbooth.LimitHandler(loginLimiter, auth.AdminLogin(db, contactSender))).Methods(http.MethodPost)
+
+	adminRouter := router.PathPrefix("/admin").Subrouter()
+	adminRouter.Use(auth.RequireClientType(db, shared.ClientTypeAdmin))
+	adminRouter.HandleFunc("/users", admin.GetAllUsers(db)).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/mechanics", admin.GetAllMechanics(db)).Methods(http.MethodGet)
+	adminRouter.HandleFunc("/orders", order.GetAllServiceOrders(db)).Methods(http.MethodGet)
 }
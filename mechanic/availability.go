@@ -0,0 +1,92 @@
+package mechanic
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Availability tells if a mechanic can take orders
+type Availability string
+
+const (
+	// AvailabilityOnline the mechanic is waiting for orders
+	AvailabilityOnline Availability = "online"
+	// AvailabilityOffline the mechanic is not working
+	AvailabilityOffline Availability = "offline"
+	// AvailabilityBusy the mechanic is working on an order
+	AvailabilityBusy Availability = "busy"
+)
+
+// HeartbeatTimeout is how long an online mechanic is considered available without reporting its availability again
+const HeartbeatTimeout = 5 * time.Minute
+
+// AvailabilityStatus is the last reported availability of a mechanic
+type AvailabilityStatus struct {
+	MechanicID      int          `json:"mechanic_id"`
+	Status          Availability `json:"status"`
+	LastHeartbeatAt time.Time    `json:"last_heartbeat_at"`
+}
+
+// IsAvailabilitySettable tells if a mechanic can report the availability, busy is only set by taking an order
+func IsAvailabilitySettable(availability Availability) bool {
+	return availability == AvailabilityOnline || availability == AvailabilityOffline
+}
+
+// IsAvailabilityValid tells if the availability is one of the known ones
+func IsAvailabilityValid(availability Availability) bool {
+	switch availability {
+	case AvailabilityOnline, AvailabilityOffline, AvailabilityBusy:
+		return true
+	}
+
+	return false
+}
+
+// SetMechanicAvailability stores the availability of the mechanic, it also works as its heartbeat. A busy mechanic
+// stays busy while it has an order in progress, only its heartbeat is updated
+func SetMechanicAvailability(db *sql.DB, id int, availability Availability) (*AvailabilityStatus, error) {
+	query := `INSERT INTO mechanic_availability_table (mechanic_id, status, last_heartbeat_at) VALUES ($1, $2, NOW())
+	ON CONFLICT (mechanic_id) DO UPDATE SET
+		status = CASE WHEN mechanic_availability_table.status = $3 AND EXISTS (
+			SELECT 1 FROM service_order_table WHERE service_order_table.mechanic_id = $1 AND service_order_table.status = 'in_progress'
+		) THEN mechanic_availability_table.status ELSE $2 END,
+		last_heartbeat_at = NOW()
+	RETURNING status, last_heartbeat_at`
+
+	status := AvailabilityStatus{MechanicID: id}
+	err := db.QueryRow(query, id, availability, AvailabilityBusy).Scan(&status.Status, &status.LastHeartbeatAt)
+	if err != nil {
+		log.Println("failed_to_set_mechanic_availability: " + err.Error())
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// MarkMechanicBusy marks the mechanic as busy as part of the transaction that assigns it an order
+func MarkMechanicBusy(tx *sql.Tx, id int) error {
+	query := `INSERT INTO mechanic_availability_table (mechanic_id, status, last_heartbeat_at) VALUES ($1, $2, NOW())
+	ON CONFLICT (mechanic_id) DO UPDATE SET status = $2`
+
+	_, err := tx.Exec(query, id, AvailabilityBusy)
+	if err != nil {
+		log.Println("failed_to_mark_mechanic_busy: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseBusyMechanic puts a busy mechanic back online once its order is over, a mechanic that went offline stays offline
+func ReleaseBusyMechanic(tx *sql.Tx, id int) error {
+	query := "UPDATE mechanic_availability_table SET status = $1 WHERE mechanic_id = $2 AND status = $3"
+
+	_, err := tx.Exec(query, AvailabilityOnline, id, AvailabilityBusy)
+	if err != nil {
+		log.Println("failed_to_release_busy_mechanic: " + err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package mechanic
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Location is the last reported position of a mechanic
+type Location struct {
+	MechanicID int       `json:"mechanic_id"`
+	Lat        float64   `json:"lat"`
+	Lng        float64   `json:"lng"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// UpdateMechanicLocation stores the last reported position of the mechanic
+func UpdateMechanicLocation(db *sql.DB, id int, lat float64, lng float64) (*Location, error) {
+	query := `INSERT INTO mechanic_location_table (mechanic_id, lat, lng, updated_at) VALUES ($1, $2, $3, NOW())
+	ON CONFLICT (mechanic_id) DO UPDATE SET lat = $2, lng = $3, updated_at = NOW()
+	RETURNING updated_at`
+
+	location := Location{MechanicID: id, Lat: lat, Lng: lng}
+	err := db.QueryRow(query, id, lat, lng).Scan(&location.UpdatedAt)
+	if err != nil {
+		log.Println("failed_to_update_mechanic_location: " + err.Error())
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+// GetMechanicLocation returns the last reported position of the mechanic
+func GetMechanicLocation(db *sql.DB, id int) (*Location, error) {
+	query := "SELECT mechanic_id, lat, lng, updated_at FROM mechanic_location_table WHERE mechanic_id = $1"
+
+	location := Location{}
+	err := db.QueryRow(query, id).Scan(&location.MechanicID, &location.Lat, &location.Lng, &location.UpdatedAt)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("failed_to_get_mechanic_location: " + err.Error())
+		}
+
+		return nil, err
+	}
+
+	return &location, nil
+}
+
+// GetAvailableMechanicLocations returns the last reported position of the mechanics that offer the service,
+// are online, not busy and sent a heartbeat within the timeout
+func GetAvailableMechanicLocations(db *sql.DB, serviceID int, heartbeatTimeout time.Duration) ([]Location, error) {
+	query := `SELECT mechanic_location_table.mechanic_id, lat, lng, updated_at FROM mechanic_location_table
+	INNER JOIN mechanic_table ON mechanic_table.mechanic_id = mechanic_location_table.mechanic_id
+	INNER JOIN mechanic_availability_table ON mechanic_availability_table.mechanic_id = mechanic_location_table.mechanic_id
+	INNER JOIN mechanic_service_table ON mechanic_service_table.mechanic_id = mechanic_location_table.mechanic_id AND mechanic_service_table.service_id = $3
+	WHERE mechanic_table.deleted_at IS NULL AND mechanic_availability_table.status = $1 AND mechanic_availability_table.last_heartbeat_at > $2`
+
+	rows, err := db.Query(query, AvailabilityOnline, time.Now().Add(-heartbeatTimeout), serviceID)
+	if err != nil {
+		log.Println("failed_to_get_mechanic_locations: " + err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	locations := []Location{}
+	for rows.Next() {
+		location := Location{}
+		err := rows.Scan(&location.MechanicID, &location.Lat, &location.Lng, &location.UpdatedAt)
+		if err != nil {
+			log.Println("failed_to_scan_mechanic_location: " + err.Error())
+			return nil, err
+		}
+
+		locations = append(locations, location)
+	}
+
+	return locations, rows.Err()
+}
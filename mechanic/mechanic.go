@@ -1,16 +1,23 @@
 package mechanic
 
+import "time"
+
 // Mechanic represents a mechanic
 type Mechanic struct {
-	MechanicID  int     `json:"mechanic_id"`
-	Name        string  `json:"name"`
-	LastName    string  `json:"last_name"`
-	Email       string  `json:"email"`
-	NationalID  string  `json:"national_id"`
-	Password    string  `json:"password"`
-	Score       float32 `json:"score"`
-	Bio         string  `json:"bio"`
-	PhoneNumber string  `json:"phone_number"`
+	MechanicID    int     `json:"mechanic_id"`
+	Name          string  `json:"name"`
+	LastName      string  `json:"last_name"`
+	Email         string  `json:"email"`
+	NationalID    string  `json:"national_id"`
+	Password      string  `json:"password"`
+	Score         float32 `json:"score"`
+	Bio           string  `json:"bio"`
+	PhoneNumber   string  `json:"phone_number"`
+	VerifiedEmail bool    `json:"verified_email"`
+	VerifiedPhone bool    `json:"verified_phone"`
+	Locale        string  `json:"locale"`
+
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty"`
 }
 
 func (mechanic Mechanic) client() {}
@@ -3,6 +3,7 @@ package mechanic
 import (
 	"database/sql"
 	"log"
+	"time"
 
 	"github.com/CartechAPI/shared"
 	"github.com/lib/pq"
@@ -10,6 +11,8 @@ import (
 
 const uniqueViolationCode = "23505"
 
+const mechanicColumns = "mechanic_id, name, last_name, email, national_id, password, score, bio, phone_number, verified_email, verified_phone, locale, deletion_requested_at"
+
 var (
 	// ErrNotUniqueField not unique field
 	ErrNotUniqueField = shared.NewBadRequestError("not unique fields")
@@ -28,36 +31,224 @@ var uniqueConstraintsErrs = map[string]error{
 }
 
 // InsertMechanic creates a new mechanic on the database
-func InsertMechanic(db *sql.DB, mechanic Mechanic) error {
+func InsertMechanic(db *sql.DB, mechanic *Mechanic) (*Mechanic, error) {
 	query := "INSERT INTO mechanic_table (name, last_name, email, national_id, password, phone_number) VALUES($1,$2,$3,$4,$5,$6) RETURNING mechanic_id"
 
 	err := db.QueryRow(query, mechanic.Name, mechanic.LastName, mechanic.Email, mechanic.NationalID, mechanic.Password, mechanic.PhoneNumber).Scan(&mechanic.MechanicID)
 	if err != nil {
-		pqError := err.(*pq.Error)
-		if pqError.Code == uniqueViolationCode {
-			if err, ok := uniqueConstraintsErrs[pqError.Constraint]; ok {
-				return err
-			}
+		return nil, mapUniqueViolation(err)
+	}
+
+	return mechanic, nil
+}
+
+// GetMechanicByEmail returns a mechanig given its email
+func GetMechanicByEmail(db *sql.DB, email string) (*Mechanic, error) {
+	query := "SELECT " + mechanicColumns + " FROM mechanic_table WHERE email = $1"
+
+	return scanMechanic(db.QueryRow(query, email))
+}
+
+// GetMechanicByID returns a mechanic given its id
+func GetMechanicByID(db *sql.DB, id int) (*Mechanic, error) {
+	query := "SELECT " + mechanicColumns + " FROM mechanic_table WHERE mechanic_id = $1"
+
+	return scanMechanic(db.QueryRow(query, id))
+}
+
+// GetAllMechanics returns a page of the mechanics ordered by id
+func GetAllMechanics(db *sql.DB, limit int, offset int) ([]Mechanic, error) {
+	query := "SELECT " + mechanicColumns + " FROM mechanic_table ORDER BY mechanic_id LIMIT $1 OFFSET $2"
+
+	rows, err := db.Query(query, limit, offset)
+	if err != nil {
+		log.Println("failed_to_get_mechanics: " + err.Error())
+		return nil, err
+	}
 
-			return ErrNotUniqueField
+	defer rows.Close()
+
+	mechanics := []Mechanic{}
+	for rows.Next() {
+		mechanic, err := scanMechanic(rows)
+		if err != nil {
+			return nil, err
 		}
 
-		return err
+		mechanics = append(mechanics, *mechanic)
 	}
 
-	return nil
+	return mechanics, nil
 }
 
-// GetMechanicByEmail returns a mechanig given its email
-func GetMechanicByEmail(db *sql.DB, email string) (*Mechanic, error) {
-	query := "SELECT * FROM mechanic_table WHERE email = $1"
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
 
+func scanMechanic(row scanner) (*Mechanic, error) {
 	mechanic := Mechanic{}
-	err := db.QueryRow(query, email).Scan(&mechanic.MechanicID, &mechanic.Name, &mechanic.LastName, &mechanic.Email, &mechanic.NationalID, &mechanic.Password, &mechanic.Score, &mechanic.Bio, &mechanic.PhoneNumber)
+	var bio, locale sql.NullString
+	var deletionRequestedAt sql.NullTime
+	err := row.Scan(&mechanic.MechanicID, &mechanic.Name, &mechanic.LastName, &mechanic.Email, &mechanic.NationalID, &mechanic.Password, &mechanic.Score, &bio, &mechanic.PhoneNumber, &mechanic.VerifiedEmail, &mechanic.VerifiedPhone, &locale, &deletionRequestedAt)
 	if err != nil {
 		log.Println(err.Error())
 		return nil, err
 	}
 
+	mechanic.Bio = bio.String
+	mechanic.Locale = locale.String
+	if mechanic.Locale == "" {
+		mechanic.Locale = string(shared.DefaultLocale)
+	}
+	if deletionRequestedAt.Valid {
+		mechanic.DeletionRequestedAt = &deletionRequestedAt.Time
+	}
+
 	return &mechanic, nil
 }
+
+// UpdateMechanicPassword replaces the password hash of the mechanic
+func UpdateMechanicPassword(db *sql.DB, id int, hashedPassword string) error {
+	query := "UPDATE mechanic_table SET password = $1 WHERE mechanic_id = $2"
+
+	_, err := db.Exec(query, hashedPassword, id)
+	if err != nil {
+		log.Println("failed_to_update_mechanic_password: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetMechanicEmailVerified marks the email of the mechanic as verified
+func SetMechanicEmailVerified(db *sql.DB, id int) error {
+	query := "UPDATE mechanic_table SET verified_email = TRUE WHERE mechanic_id = $1"
+
+	_, err := db.Exec(query, id)
+	if err != nil {
+		log.Println("failed_to_verify_mechanic_email: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetMechanicPhoneVerified marks the phone number of the mechanic as verified
+func SetMechanicPhoneVerified(db *sql.DB, id int) error {
+	query := "UPDATE mechanic_table SET verified_phone = TRUE WHERE mechanic_id = $1"
+
+	_, err := db.Exec(query, id)
+	if err != nil {
+		log.Println("failed_to_verify_mechanic_phone: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// UpdateMechanic updates the profile fields of the mechanic, the phone number stops being verified if it changes
+func UpdateMechanic(db *sql.DB, mechanic Mechanic) error {
+	query := `UPDATE mechanic_table SET name = $1, last_name = $2, bio = $3, verified_phone = (verified_phone AND phone_number = $4), phone_number = $4, locale = $5
+	WHERE mechanic_id = $6`
+
+	_, err := db.Exec(query, mechanic.Name, mechanic.LastName, mechanic.Bio, mechanic.PhoneNumber, mechanic.Locale, mechanic.MechanicID)
+	if err != nil {
+		log.Println("failed_to_update_mechanic: " + err.Error())
+		return mapUniqueViolation(err)
+	}
+
+	return nil
+}
+
+// mapUniqueViolation returns the public error of a unique constraint violation, other errors are returned as they are
+func mapUniqueViolation(err error) error {
+	pqError, ok := err.(*pq.Error)
+	if !ok || pqError.Code != uniqueViolationCode {
+		return err
+	}
+
+	if err, ok := uniqueConstraintsErrs[pqError.Constraint]; ok {
+		return err
+	}
+
+	return ErrNotUniqueField
+}
+
+// RequestMechanicDeletion schedules the anonymization of the mechanic
+func RequestMechanicDeletion(db *sql.DB, id int) error {
+	query := "UPDATE mechanic_table SET deletion_requested_at = NOW() WHERE mechanic_id = $1 AND deleted_at IS NULL"
+
+	_, err := db.Exec(query, id)
+	if err != nil {
+		log.Println("failed_to_request_mechanic_deletion: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// CancelMechanicDeletion cancels a scheduled anonymization, it returns false if there was none
+func CancelMechanicDeletion(db *sql.DB, id int) (bool, error) {
+	query := "UPDATE mechanic_table SET deletion_requested_at = NULL WHERE mechanic_id = $1 AND deletion_requested_at IS NOT NULL AND deleted_at IS NULL"
+
+	result, err := db.Exec(query, id)
+	if err != nil {
+		log.Println("failed_to_cancel_mechanic_deletion: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// AnonymizeDeletedMechanics wipes the personal data of the mechanics that requested their deletion before the given time.
+// The rows are kept so the service orders still reference them, the location and availability of the mechanics
+// are deleted. It returns the anonymized mechanics with the email they had
+func AnonymizeDeletedMechanics(tx *sql.Tx, requestedBefore time.Time) ([]shared.AnonymizedClient, error) {
+	query := `UPDATE mechanic_table m SET
+		name = 'Deleted', last_name = 'Mechanic', email = 'deleted-' || m.mechanic_id || '@deleted.cartech', phone_number = 'deleted-' || m.mechanic_id,
+		national_id = 'deleted-' || m.mechanic_id, bio = '', password = '', verified_email = FALSE, verified_phone = FALSE, deleted_at = NOW()
+	FROM (SELECT mechanic_id, email FROM mechanic_table WHERE deletion_requested_at <= $1 AND deleted_at IS NULL FOR UPDATE) old
+	WHERE m.mechanic_id = old.mechanic_id
+	RETURNING m.mechanic_id, old.email`
+
+	rows, err := tx.Query(query, requestedBefore)
+	if err != nil {
+		log.Println("failed_to_anonymize_mechanics: " + err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	mechanics := []shared.AnonymizedClient{}
+	mechanicIDs := []int64{}
+	for rows.Next() {
+		mechanic := shared.AnonymizedClient{}
+		err = rows.Scan(&mechanic.ClientID, &mechanic.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		mechanics = append(mechanics, mechanic)
+		mechanicIDs = append(mechanicIDs, int64(mechanic.ClientID))
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, table := range []string{"mechanic_location_table", "mechanic_availability_table"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE mechanic_id = ANY($1)", pq.Array(mechanicIDs))
+		if err != nil {
+			log.Println("error deleting from " + table + ": " + err.Error())
+			return nil, err
+		}
+	}
+
+	return mechanics, nil
+}
@@ -0,0 +1,86 @@
+package mechanic
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/CartechAPI/shared"
+	"github.com/lib/pq"
+)
+
+const foreignKeyViolationCode = "23503"
+
+// ErrUnknownService the service does not exist
+var ErrUnknownService = shared.NewBadRequestError("unknown service")
+
+// SetMechanicServices replaces the services the mechanic offers
+func SetMechanicServices(db *sql.DB, id int, serviceIDs []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM mechanic_service_table WHERE mechanic_id = $1", id)
+	if err != nil {
+		log.Println("failed_to_delete_mechanic_services: " + err.Error())
+		return err
+	}
+
+	for _, serviceID := range serviceIDs {
+		_, err = tx.Exec("INSERT INTO mechanic_service_table (mechanic_id, service_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", id, serviceID)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == foreignKeyViolationCode {
+			return ErrUnknownService
+		}
+
+		if err != nil {
+			log.Println("failed_to_insert_mechanic_service: " + err.Error())
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetMechanicServiceIDs returns the ids of the services the mechanic offers
+func GetMechanicServiceIDs(db *sql.DB, id int) ([]int, error) {
+	query := "SELECT service_id FROM mechanic_service_table WHERE mechanic_id = $1 ORDER BY service_id"
+
+	rows, err := db.Query(query, id)
+	if err != nil {
+		log.Println("failed_to_get_mechanic_services: " + err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	serviceIDs := []int{}
+	for rows.Next() {
+		var serviceID int
+		err := rows.Scan(&serviceID)
+		if err != nil {
+			log.Println("failed_to_scan_mechanic_service: " + err.Error())
+			return nil, err
+		}
+
+		serviceIDs = append(serviceIDs, serviceID)
+	}
+
+	return serviceIDs, rows.Err()
+}
+
+// OffersService tells if the mechanic offers the service
+func OffersService(db *sql.DB, id int, serviceID int) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM mechanic_service_table WHERE mechanic_id = $1 AND service_id = $2)"
+
+	var offers bool
+	err := db.QueryRow(query, id, serviceID).Scan(&offers)
+	if err != nil {
+		log.Println("failed_to_check_mechanic_service: " + err.Error())
+		return false, err
+	}
+
+	return offers, nil
+}
@@ -0,0 +1,64 @@
+package notifications
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CartechAPI/auth"
+	"github.com/CartechAPI/shared"
+	"github.com/CartechAPI/utils"
+)
+
+// GetNotificationPreferences handles the request of a client for reading its notification preferences
+func GetNotificationPreferences(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		preferences, err := GetPreferences(db, clientType, id)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, preferences)
+	}
+}
+
+// UpdateNotificationPreferences handles the request of a client for replacing its notification preferences,
+// the channels and categories left out are enabled
+func UpdateNotificationPreferences(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		body := Preferences{}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err = body.Validate()
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		preferences := body.withDefaults()
+		err = SavePreferences(db, clientType, id, preferences)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, preferences)
+	}
+}
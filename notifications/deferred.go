@@ -0,0 +1,81 @@
+package notifications
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// RunDeferredDelivery sends the notifications whose quiet hours are over every interval until stop is closed
+func RunDeferredDelivery(db *sql.DB, notifier Notifier, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := deliverDeferred(db, notifier, time.Now())
+			if err != nil {
+				log.Println("error_delivering_deferred_notifications: " + err.Error())
+			}
+		}
+	}
+}
+
+// deliverDeferred sends a batch of due notifications as the current preferences of the clients allow. A notification
+// the client muted since it was deferred is dropped and one whose client is quiet again waits for the new end of its
+// quiet hours. A notification that can not be sent is dropped like it would have been if it was sent right away,
+// so one client can not block the rest
+func deliverDeferred(db *sql.DB, notifier Notifier, now time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	notifications, err := selectDueNotifications(tx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, notification := range notifications {
+		err = deliverDeferredNotification(db, tx, notifier, notification, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// deliverDeferredNotification sends, postpones or drops the notification, only failing if the batch can not go on
+func deliverDeferredNotification(db *sql.DB, tx *sql.Tx, notifier Notifier, notification DeferredNotification, now time.Time) error {
+	preferences, err := GetPreferences(db, notification.ClientType, notification.ClientID)
+	if err != nil {
+		return err
+	}
+
+	category, err := EventCategory(notification.Event)
+	if err != nil {
+		log.Println("error_sending_deferred_notification: " + err.Error())
+		return deleteDeferredNotification(tx, notification.DeferredNotificationID)
+	}
+
+	send, deliverAt := preferences.Delivery(ChannelPush, category, now)
+	if send && deliverAt.After(now) {
+		return postponeDeferredNotification(tx, notification.DeferredNotificationID, deliverAt)
+	}
+
+	if send {
+		err = sendToClient(db, notifier, notification.ClientType, notification.ClientID, notification.Event, notification.Params)
+		if err != nil {
+			log.Println("error_sending_deferred_notification: " + err.Error())
+		}
+	}
+
+	return deleteDeferredNotification(tx, notification.DeferredNotificationID)
+}
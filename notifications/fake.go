@@ -0,0 +1,70 @@
+package notifications
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// SentNotification is a notification delivered by a fake notifier
+type SentNotification struct {
+	Token        string       `json:"token"`
+	Notification Notification `json:"notification"`
+	SentAt       time.Time    `json:"sent_at"`
+}
+
+// RecordingNotifier keeps the notifications in memory, meant for tests
+type RecordingNotifier struct {
+	mu   sync.Mutex
+	sent []SentNotification
+}
+
+// SendToDevice records the notification
+func (notifier *RecordingNotifier) SendToDevice(token string, notification Notification) error {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	notifier.sent = append(notifier.sent, SentNotification{Token: token, Notification: notification, SentAt: time.Now()})
+	return nil
+}
+
+// Sent returns the recorded notifications
+func (notifier *RecordingNotifier) Sent() []SentNotification {
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	return append([]SentNotification{}, notifier.sent...)
+}
+
+// FileNotifier appends the notifications to a file as json lines, meant for local development
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileNotifier returns a notifier writing to the file on the given path
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+// SendToDevice appends the notification to the file
+func (notifier *FileNotifier) SendToDevice(token string, notification Notification) error {
+	line, err := json.Marshal(SentNotification{Token: token, Notification: notification, SentAt: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	file, err := os.OpenFile(notifier.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,61 @@
+package notifications
+
+import (
+	"context"
+	"log"
+
+	firebase "firebase.google.com/go"
+	"firebase.google.com/go/messaging"
+	"google.golang.org/api/option"
+)
+
+// FCMNotifier sends the notifications through firebase cloud messaging
+type FCMNotifier struct {
+	client *messaging.Client
+}
+
+// NewFCMNotifier creates the firebase client once, so it is reused by every notification
+func NewFCMNotifier(credentialsFile string, serviceAccountID string, projectID string) (*FCMNotifier, error) {
+	ctx := context.Background()
+
+	conf := &firebase.Config{
+		ServiceAccountID: serviceAccountID,
+		ProjectID:        projectID,
+	}
+
+	app, err := firebase.NewApp(ctx, conf, option.WithCredentialsFile(credentialsFile))
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := app.Messaging(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FCMNotifier{client: client}, nil
+}
+
+// SendToDevice sends the notification to the device
+func (notifier *FCMNotifier) SendToDevice(token string, notification Notification) error {
+	message := messaging.Message{
+		Token: token,
+		Notification: &messaging.Notification{
+			Title: notification.Title,
+			Body:  notification.Body,
+		},
+	}
+
+	response, err := notifier.client.Send(context.Background(), &message)
+	if messaging.IsRegistrationTokenNotRegistered(err) {
+		return ErrUnregisteredToken
+	}
+
+	if err != nil {
+		return err
+	}
+
+	log.Println("response_from_fcm: " + response)
+
+	return nil
+}
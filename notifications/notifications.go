@@ -1,98 +1,140 @@
 package notifications
 
 import (
-	"context"
-	"fmt"
+	"database/sql"
+	"errors"
 	"log"
 	"os"
+	"time"
 
-	firebase "firebase.google.com/go"
-	"firebase.google.com/go/messaging"
-	"google.golang.org/api/option"
+	"github.com/CartechAPI/auth"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/shared"
+	us "github.com/CartechAPI/user"
 )
 
-// SendNotificationToMechanics sends a notification to a the mechanics topic
-func SendNotificationToMechanics(title string, body string) error {
-	ctx := context.Background()
+// defaultCredentialsFile is where the firebase credentials are when FIREBASE_CREDENTIALS_FILE is not set
+const defaultCredentialsFile = "credentials/cartech-12e63-ffeab1e71964.json"
 
-	fmt.Println(os.Getenv("SERVICE_ACCOUNT_ID"))
-	opt := option.WithCredentialsFile("../credentials/cartech-12e63-ffeab1e71964.json")
-	conf := &firebase.Config{
-		ServiceAccountID: os.Getenv("SERVICE_ACCOUNT_ID"),
-		ProjectID:        os.Getenv("FIREBASE_PROJECT_ID"),
-	}
+var (
+	// ErrUnregisteredToken the device token is no longer valid
+	ErrUnregisteredToken = errors.New("device token is not registered")
+)
 
-	app, err := firebase.NewApp(ctx, conf, opt)
-	if err != nil {
-		return err
-	}
+// Notification is a push notification
+type Notification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
 
-	client, err := app.Messaging(ctx)
-	if err != nil {
-		return err
-	}
-	message := messaging.Message{
-		Topic: "mechanic",
-		Notification: &messaging.Notification{
-			Title: title,
-			Body:  body,
-		},
-	}
+// Notifier delivers push notifications to the devices of the clients
+type Notifier interface {
+	// SendToDevice sends the notification to the device, it returns ErrUnregisteredToken if the device token is no longer valid
+	SendToDevice(token string, notification Notification) error
+}
 
-	response, err := client.Send(ctx, &message)
-	if err != nil {
-		return err
+// FromEnv returns the notifier configured on the environment. It writes to a file when NOTIFIER_FILE is set
+// and uses firebase with the credentials on FIREBASE_CREDENTIALS_FILE otherwise
+func FromEnv() (Notifier, error) {
+	if path := os.Getenv("NOTIFIER_FILE"); path != "" {
+		return NewFileNotifier(path), nil
 	}
 
-	fmt.Println("HERE")
+	credentialsFile := os.Getenv("FIREBASE_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		credentialsFile = defaultCredentialsFile
+	}
 
-	fmt.Println("response_from_fmc: " + response)
+	return NewFCMNotifier(credentialsFile, os.Getenv("SERVICE_ACCOUNT_ID"), os.Getenv("FIREBASE_PROJECT_ID"))
+}
 
-	return nil
+// IsUnregisteredToken tells if the error means the device token is no longer valid
+func IsUnregisteredToken(err error) bool {
+	return err == ErrUnregisteredToken
 }
 
-// SendNotificationToSingleUser sends a notification to a single user with the specified token
-func SendNotificationToSingleUser(token string, title string, body string) error {
-	ctx := context.Background()
+// NotifyClient sends the event to the client as its preferences allow. Transactional events are sent right away,
+// the rest are dropped if the client muted them and wait for its quiet hours to end
+func NotifyClient(db *sql.DB, notifier Notifier, clientType shared.ClientType, clientID int, event Event, params Params) error {
+	preferences, err := GetPreferences(db, clientType, clientID)
+	if err != nil {
+		return err
+	}
 
-	path, err := os.Getwd()
+	category, err := EventCategory(event)
 	if err != nil {
 		return err
 	}
 
-	log.Println(path)
+	now := time.Now()
+	send, deliverAt := preferences.Delivery(ChannelPush, category, now)
+	if !send {
+		return nil
+	}
 
-	opt := option.WithCredentialsFile("credentials/cartech-12e63-ffeab1e71964.json")
-	conf := &firebase.Config{
-		ServiceAccountID: os.Getenv("SERVICE_ACCOUNT_ID"),
-		ProjectID:        os.Getenv("FIREBASE_PROJECT_ID"),
+	if deliverAt.After(now) {
+		return insertDeferredNotification(db, DeferredNotification{
+			ClientType: clientType,
+			ClientID:   clientID,
+			Event:      event,
+			Params:     params,
+			DeliverAt:  deliverAt,
+		})
 	}
 
-	app, err := firebase.NewApp(ctx, conf, opt)
+	return sendToClient(db, notifier, clientType, clientID, event, params)
+}
+
+// sendToClient renders the event in the locale of the client and sends it to every device of the client,
+// forgetting the devices whose token is no longer valid. A failure on one device does not stop the others from being notified
+func sendToClient(db *sql.DB, notifier Notifier, clientType shared.ClientType, clientID int, event Event, params Params) error {
+	locale, err := clientLocale(db, clientType, clientID)
 	if err != nil {
 		return err
 	}
 
-	client, err := app.Messaging(ctx)
+	notification, err := Render(event, locale, params)
 	if err != nil {
 		return err
 	}
-	message := messaging.Message{
-		Token: token,
-		Notification: &messaging.Notification{
-			Title: title,
-			Body:  body,
-		},
-	}
 
-	response, err := client.Send(ctx, &message)
+	sessions, err := auth.GetClientSessions(db, clientType, clientID)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("HERE")
+	for _, session := range sessions {
+		err = notifier.SendToDevice(session.Token, notification)
+		if IsUnregisteredToken(err) {
+			err = auth.ForgetDevice(db, session.Token)
+		}
 
-	fmt.Println("response_from_fmc: " + response)
+		if err != nil {
+			log.Println("error_notifying_device: " + err.Error())
+		}
+	}
 
 	return nil
 }
+
+// clientLocale returns the locale the client chose
+func clientLocale(db *sql.DB, clientType shared.ClientType, clientID int) (shared.Locale, error) {
+	switch clientType {
+	case shared.ClientTypeUser:
+		user, err := us.GetUserByID(db, clientID)
+		if err != nil {
+			return "", err
+		}
+
+		return shared.Locale(user.Locale), nil
+	case shared.ClientTypeMechanic:
+		mechanic, err := mec.GetMechanicByID(db, clientID)
+		if err != nil {
+			return "", err
+		}
+
+		return shared.Locale(mechanic.Locale), nil
+	}
+
+	return shared.DefaultLocale, nil
+}
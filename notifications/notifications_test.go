@@ -0,0 +1,419 @@
+package notifications
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CartechAPI/shared"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingNotifier(t *testing.T) {
+	c := require.New(t)
+
+	var notifier Notifier = &RecordingNotifier{}
+	c.Nil(notifier.SendToDevice("device-1", Notification{Title: "title", Body: "body"}))
+	c.Nil(notifier.SendToDevice("device-2", Notification{Title: "other", Body: "body"}))
+
+	sent := notifier.(*RecordingNotifier).Sent()
+	c.Len(sent, 2)
+	c.Equal("device-1", sent[0].Token)
+	c.Equal(Notification{Title: "title", Body: "body"}, sent[0].Notification)
+	c.Equal("device-2", sent[1].Token)
+}
+
+func TestFileNotifier(t *testing.T) {
+	c := require.New(t)
+
+	dir, err := ioutil.TempDir("", "notifier")
+	c.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "notifications.log")
+	notifier := NewFileNotifier(path)
+	c.Nil(notifier.SendToDevice("device-1", Notification{Title: "title", Body: "body"}))
+	c.Nil(notifier.SendToDevice("device-2", Notification{Title: "other", Body: "body"}))
+
+	file, err := os.Open(path)
+	c.Nil(err)
+	defer file.Close()
+
+	lines := []SentNotification{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		sent := SentNotification{}
+		c.Nil(json.Unmarshal(scanner.Bytes(), &sent))
+		lines = append(lines, sent)
+	}
+
+	c.Len(lines, 2)
+	c.Equal("device-2", lines[1].Token)
+	c.Equal("other", lines[1].Notification.Title)
+}
+
+func TestIsUnregisteredToken(t *testing.T) {
+	c := require.New(t)
+
+	c.True(IsUnregisteredToken(ErrUnregisteredToken))
+	c.False(IsUnregisteredToken(nil))
+}
+
+func TestRender(t *testing.T) {
+	c := require.New(t)
+
+	params := Params{"mechanic_name": "Juan Perez", "service_name": "Cambio de aceite", "eta": "12"}
+
+	notification, err := Render(EventOrderAssigned, shared.LocaleSpanish, params)
+	c.Nil(err)
+	c.Equal("Un mecanico ha tomado tu orden", notification.Title)
+	c.Equal("Juan Perez tomo tu orden de Cambio de aceite y llegara en aproximadamente 12 minutos", notification.Body)
+
+	notification, err = Render(EventOrderAssigned, shared.LocaleEnglish, params)
+	c.Nil(err)
+	c.Equal("Juan Perez took your Cambio de aceite order and will arrive in about 12 minutes", notification.Body)
+
+	notification, err = Render(EventOrderAssigned, shared.Locale("fr"), params)
+	c.Nil(err)
+	c.Equal("Un mecanico ha tomado tu orden", notification.Title)
+
+	_, err = Render(EventOrderAssigned, shared.LocaleSpanish, Params{"service_name": "Cambio de aceite"})
+	c.Equal(ErrMissingParam, err)
+
+	_, err = Render(Event("unknown"), shared.LocaleSpanish, params)
+	c.Equal(ErrUnknownEvent, err)
+}
+
+func TestTemplatesHaveEveryLocale(t *testing.T) {
+	c := require.New(t)
+
+	for event, variants := range templates {
+		c.Contains(variants, shared.LocaleSpanish, string(event))
+		c.Contains(variants, shared.LocaleEnglish, string(event))
+	}
+}
+
+func TestQuietHoursEndAfter(t *testing.T) {
+	c := require.New(t)
+
+	overnight := QuietHours{Start: "22:00", End: "07:30", Timezone: "UTC"}
+
+	endsAt, quiet := overnight.EndAfter(time.Date(2020, 5, 1, 23, 15, 0, 0, time.UTC))
+	c.True(quiet)
+	c.Equal(time.Date(2020, 5, 2, 7, 30, 0, 0, time.UTC), endsAt.UTC())
+
+	endsAt, quiet = overnight.EndAfter(time.Date(2020, 5, 2, 6, 0, 0, 0, time.UTC))
+	c.True(quiet)
+	c.Equal(time.Date(2020, 5, 2, 7, 30, 0, 0, time.UTC), endsAt.UTC())
+
+	_, quiet = overnight.EndAfter(time.Date(2020, 5, 2, 7, 30, 0, 0, time.UTC))
+	c.False(quiet)
+
+	daytime := QuietHours{Start: "13:00", End: "15:00", Timezone: "UTC"}
+	_, quiet = daytime.EndAfter(time.Date(2020, 5, 2, 12, 59, 0, 0, time.UTC))
+	c.False(quiet)
+
+	endsAt, quiet = daytime.EndAfter(time.Date(2020, 5, 2, 14, 0, 0, 0, time.UTC))
+	c.True(quiet)
+	c.Equal(time.Date(2020, 5, 2, 15, 0, 0, 0, time.UTC), endsAt.UTC())
+}
+
+func TestQuietHoursValidate(t *testing.T) {
+	c := require.New(t)
+
+	c.NoError(QuietHours{Start: "22:00", End: "07:00", Timezone: "UTC"}.Validate())
+	c.Equal(ErrInvalidQuietHours, QuietHours{Start: "22:00", End: "22:00", Timezone: "UTC"}.Validate())
+	c.Equal(ErrInvalidQuietHours, QuietHours{Start: "24:00", End: "07:00", Timezone: "UTC"}.Validate())
+	c.Equal(ErrInvalidQuietHours, QuietHours{Start: "7:00", End: "09:00", Timezone: "UTC"}.Validate())
+	c.Equal(ErrInvalidQuietHours, QuietHours{Start: "22:00", End: "07:00"}.Validate())
+	c.Equal(ErrInvalidQuietHours, QuietHours{Start: "22:00", End: "07:00", Timezone: "Nowhere/Unknown"}.Validate())
+}
+
+func TestPreferencesValidate(t *testing.T) {
+	c := require.New(t)
+
+	c.NoError(Preferences{}.Validate())
+	c.NoError(Preferences{Channels: map[Channel]bool{ChannelSMS: false}, Categories: map[Category]bool{CategoryPromotions: false}}.Validate())
+	c.Equal(ErrInvalidPreferences, Preferences{Channels: map[Channel]bool{"pigeon": true}}.Validate())
+	c.Equal(ErrInvalidPreferences, Preferences{Categories: map[Category]bool{"unknown": true}}.Validate())
+	c.Equal(ErrInvalidPreferences, Preferences{Categories: map[Category]bool{CategoryOrders: false}}.Validate())
+}
+
+func TestPreferencesDelivery(t *testing.T) {
+	c := require.New(t)
+
+	now := time.Date(2020, 5, 1, 23, 0, 0, 0, time.UTC)
+	preferences := Preferences{
+		Channels:   map[Channel]bool{ChannelPush: true, ChannelEmail: false},
+		Categories: map[Category]bool{CategoryNews: false},
+		QuietHours: &QuietHours{Start: "22:00", End: "07:00", Timezone: "UTC"},
+	}.withDefaults()
+
+	send, at := preferences.Delivery(ChannelPush, CategoryOrders, now)
+	c.True(send)
+	c.Equal(now, at)
+
+	send, at = preferences.Delivery(ChannelPush, CategoryPromotions, now)
+	c.True(send)
+	c.Equal(time.Date(2020, 5, 2, 7, 0, 0, 0, time.UTC), at.UTC())
+
+	send, _ = preferences.Delivery(ChannelPush, CategoryNews, now)
+	c.False(send)
+
+	send, _ = preferences.Delivery(ChannelEmail, CategoryPromotions, now)
+	c.False(send)
+
+	send, at = DefaultPreferences().Delivery(ChannelPush, CategoryPromotions, now)
+	c.True(send)
+	c.Equal(now, at)
+}
+
+// fakeStore answers the queries of the notifications with fixed rows and records the changes to the deferred notifications
+type fakeStore struct {
+	mu          sync.Mutex
+	preferences []driver.Value
+	deferred    [][]driver.Value
+	due         [][]driver.Value
+	postponed   [][]driver.Value
+	deleted     []driver.Value
+}
+
+func (store *fakeStore) Connect(ctx context.Context) (driver.Conn, error) {
+	return fakeConn{store: store}, nil
+}
+
+func (store *fakeStore) Driver() driver.Driver {
+	return nil
+}
+
+type fakeConn struct {
+	store *fakeStore
+}
+
+func (conn fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{store: conn.store, query: query}, nil
+}
+
+func (conn fakeConn) Close() error {
+	return nil
+}
+
+func (conn fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+// fakeTx applies the changes right away, the tests only look at what was committed
+type fakeTx struct{}
+
+func (tx fakeTx) Commit() error {
+	return nil
+}
+
+func (tx fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	store *fakeStore
+	query string
+}
+
+func (stmt fakeStmt) Close() error {
+	return nil
+}
+
+func (stmt fakeStmt) NumInput() int {
+	return -1
+}
+
+func (stmt fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	stmt.store.mu.Lock()
+	defer stmt.store.mu.Unlock()
+
+	switch {
+	case strings.Contains(stmt.query, "INSERT INTO deferred_notification_table"):
+		stmt.store.deferred = append(stmt.store.deferred, args)
+	case strings.Contains(stmt.query, "UPDATE deferred_notification_table"):
+		stmt.store.postponed = append(stmt.store.postponed, args)
+	case strings.Contains(stmt.query, "DELETE FROM deferred_notification_table"):
+		stmt.store.deleted = append(stmt.store.deleted, args[0])
+	default:
+		return nil, errors.New("unexpected exec: " + stmt.query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (stmt fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	switch {
+	case strings.Contains(stmt.query, "FROM notification_preferences_table"):
+		rows := &fakeRows{columns: 7}
+		if stmt.store.preferences != nil {
+			rows.values = [][]driver.Value{stmt.store.preferences}
+		}
+
+		return rows, nil
+	case strings.Contains(stmt.query, "FROM deferred_notification_table"):
+		return &fakeRows{columns: 6, values: stmt.store.due}, nil
+	case strings.Contains(stmt.query, "FROM user_table"):
+		return &fakeRows{columns: 10, values: [][]driver.Value{
+			{int64(1), "Ana", "Lopez", "ana@cartech.com", "", "5512345678", true, true, "en", nil},
+		}}, nil
+	case strings.Contains(stmt.query, "FROM sessions"):
+		return &fakeRows{columns: 5, values: [][]driver.Value{
+			{int64(1), time.Now(), int64(1), "user", "device-1"},
+		}}, nil
+	}
+
+	return nil, errors.New("unexpected query: " + stmt.query)
+}
+
+type fakeRows struct {
+	columns int
+	values  [][]driver.Value
+}
+
+func (rows *fakeRows) Columns() []string {
+	return make([]string, rows.columns)
+}
+
+func (rows *fakeRows) Close() error {
+	return nil
+}
+
+func (rows *fakeRows) Next(dest []driver.Value) error {
+	if len(rows.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, rows.values[0])
+	rows.values = rows.values[1:]
+
+	return nil
+}
+
+// quietNow returns quiet hours in UTC that contain the current time
+func quietNow() (driver.Value, driver.Value, driver.Value) {
+	now := time.Now().UTC()
+	return now.Add(-time.Hour).Format("15:04"), now.Add(time.Hour).Format("15:04"), "UTC"
+}
+
+func TestNotifyClientSkipsMutedCategories(t *testing.T) {
+	c := require.New(t)
+
+	store := &fakeStore{preferences: []driver.Value{true, true, true, []byte("{promotions}"), nil, nil, nil}}
+	db := sql.OpenDB(store)
+	notifier := &RecordingNotifier{}
+
+	err := NotifyClient(db, notifier, shared.ClientTypeUser, 1, EventPromotion, Params{"title": "2x1", "message": "Only today"})
+	c.Nil(err)
+	c.Empty(notifier.Sent())
+	c.Empty(store.deferred)
+}
+
+func TestNotifyClientDefersDuringQuietHours(t *testing.T) {
+	c := require.New(t)
+
+	start, end, timezone := quietNow()
+	store := &fakeStore{preferences: []driver.Value{true, true, true, []byte("{}"), start, end, timezone}}
+	db := sql.OpenDB(store)
+	notifier := &RecordingNotifier{}
+
+	err := NotifyClient(db, notifier, shared.ClientTypeUser, 1, EventPromotion, Params{"title": "2x1", "message": "Only today"})
+	c.Nil(err)
+	c.Empty(notifier.Sent())
+	c.Len(store.deferred, 1)
+	c.Equal(string(EventPromotion), store.deferred[0][2])
+	c.True(store.deferred[0][4].(time.Time).After(time.Now()))
+}
+
+func TestNotifyClientAlwaysDeliversTransactional(t *testing.T) {
+	c := require.New(t)
+
+	start, end, timezone := quietNow()
+	store := &fakeStore{preferences: []driver.Value{false, false, false, []byte("{promotions,news}"), start, end, timezone}}
+	db := sql.OpenDB(store)
+	notifier := &RecordingNotifier{}
+
+	err := NotifyClient(db, notifier, shared.ClientTypeUser, 1, EventOrderFinished, Params{"service_name": "Oil change", "mechanic_name": "Juan Perez"})
+	c.Nil(err)
+	c.Empty(store.deferred)
+	c.Len(notifier.Sent(), 1)
+	c.Equal("device-1", notifier.Sent()[0].Token)
+	c.Equal("Your order is done", notifier.Sent()[0].Notification.Title)
+
+	err = NotifyClient(db, notifier, shared.ClientTypeUser, 1, Event("unmapped"), Params{})
+	c.Equal(ErrUnknownEvent, err)
+}
+
+func TestEveryEventHasACategory(t *testing.T) {
+	c := require.New(t)
+
+	for event := range templates {
+		_, err := EventCategory(event)
+		c.Nil(err, string(event))
+	}
+}
+
+// duePromotion is a promotion deferred for the user 1 that is due now
+func duePromotion() []driver.Value {
+	return []driver.Value{int64(7), "user", int64(1), string(EventPromotion), []byte(`{"title": "2x1", "message": "Only today"}`), time.Now().Add(-time.Minute)}
+}
+
+func TestDeliverDeferredSendsDueNotifications(t *testing.T) {
+	c := require.New(t)
+
+	store := &fakeStore{due: [][]driver.Value{duePromotion()}}
+	db := sql.OpenDB(store)
+	notifier := &RecordingNotifier{}
+
+	c.Nil(deliverDeferred(db, notifier, time.Now()))
+	c.Len(notifier.Sent(), 1)
+	c.Equal("2x1", notifier.Sent()[0].Notification.Title)
+	c.Equal([]driver.Value{int64(7)}, store.deleted)
+}
+
+func TestDeliverDeferredDropsCategoriesMutedAfterDeferral(t *testing.T) {
+	c := require.New(t)
+
+	store := &fakeStore{
+		preferences: []driver.Value{true, true, true, []byte("{promotions}"), nil, nil, nil},
+		due:         [][]driver.Value{duePromotion()},
+	}
+	db := sql.OpenDB(store)
+	notifier := &RecordingNotifier{}
+
+	c.Nil(deliverDeferred(db, notifier, time.Now()))
+	c.Empty(notifier.Sent())
+	c.Empty(store.postponed)
+	c.Equal([]driver.Value{int64(7)}, store.deleted)
+}
+
+func TestDeliverDeferredPostponesDuringQuietHours(t *testing.T) {
+	c := require.New(t)
+
+	start, end, timezone := quietNow()
+	store := &fakeStore{
+		preferences: []driver.Value{true, true, true, []byte("{}"), start, end, timezone},
+		due:         [][]driver.Value{duePromotion()},
+	}
+	db := sql.OpenDB(store)
+	notifier := &RecordingNotifier{}
+
+	c.Nil(deliverDeferred(db, notifier, time.Now()))
+	c.Empty(notifier.Sent())
+	c.Empty(store.deleted)
+	c.Len(store.postponed, 1)
+	c.True(store.postponed[0][1].(time.Time).After(time.Now()))
+}
@@ -0,0 +1,249 @@
+package notifications
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CartechAPI/shared"
+)
+
+// Channel is a way of reaching a client
+type Channel string
+
+const (
+	// ChannelPush push notifications on the devices of the client
+	ChannelPush Channel = "push"
+	// ChannelSMS text messages to the phone number of the client
+	ChannelSMS Channel = "sms"
+	// ChannelEmail emails to the address of the client
+	ChannelEmail Channel = "email"
+)
+
+// Category groups the events a client can mute together
+type Category string
+
+const (
+	// CategoryOrders the lifecycle of the orders, it is transactional so it can not be muted
+	CategoryOrders Category = "orders"
+	// CategoryPromotions discounts and campaigns
+	CategoryPromotions Category = "promotions"
+	// CategoryNews news about the platform
+	CategoryNews Category = "news"
+)
+
+var (
+	// ErrInvalidPreferences the preferences have an unknown channel or category
+	ErrInvalidPreferences = shared.NewBadRequestError("invalid notification preferences")
+	// ErrInvalidQuietHours the quiet hours are not HH:MM times on a known timezone
+	ErrInvalidQuietHours = shared.NewBadRequestError("invalid quiet hours")
+)
+
+var channels = []Channel{ChannelPush, ChannelSMS, ChannelEmail}
+
+var categories = []Category{CategoryOrders, CategoryPromotions, CategoryNews}
+
+// eventCategories holds the category of every event, an event without category can not be sent
+var eventCategories = map[Event]Category{
+	EventNewOrder:             CategoryOrders,
+	EventOrderAssigned:        CategoryOrders,
+	EventMechanicAssigned:     CategoryOrders,
+	EventOrderFinished:        CategoryOrders,
+	EventOrderFailed:          CategoryOrders,
+	EventOrderCancelled:       CategoryOrders,
+	EventOrderCancelledByUser: CategoryOrders,
+	EventPromotion:            CategoryPromotions,
+}
+
+// QuietHours is the time of the day the client does not want to be disturbed, it can go past midnight
+type QuietHours struct {
+	Start    string `json:"start"`
+	End      string `json:"end"`
+	Timezone string `json:"timezone"`
+}
+
+// Preferences are how and about what a client wants to be notified
+type Preferences struct {
+	Channels   map[Channel]bool  `json:"channels"`
+	Categories map[Category]bool `json:"categories"`
+	QuietHours *QuietHours       `json:"quiet_hours"`
+}
+
+// DefaultPreferences are the preferences of the clients that did not set theirs, everything is enabled
+func DefaultPreferences() Preferences {
+	preferences := Preferences{Channels: map[Channel]bool{}, Categories: map[Category]bool{}}
+	for _, channel := range channels {
+		preferences.Channels[channel] = true
+	}
+
+	for _, category := range categories {
+		preferences.Categories[category] = true
+	}
+
+	return preferences
+}
+
+// IsTransactional tells if the category is always delivered right away
+func IsTransactional(category Category) bool {
+	return category == CategoryOrders
+}
+
+// EventCategory returns the category of the event, it fails for events without one so a new event is never
+// taken as transactional by mistake
+func EventCategory(event Event) (Category, error) {
+	category, ok := eventCategories[event]
+	if !ok {
+		return "", ErrUnknownEvent
+	}
+
+	return category, nil
+}
+
+// parseClock returns the minutes since midnight of an HH:MM time
+func parseClock(clock string) (int, error) {
+	var hours, minutes int
+	_, err := fmt.Sscanf(clock, "%d:%d", &hours, &minutes)
+	if err != nil || len(clock) != 5 || hours < 0 || hours > 23 || minutes < 0 || minutes > 59 {
+		return 0, ErrInvalidQuietHours
+	}
+
+	return hours*60 + minutes, nil
+}
+
+// Validate checks the quiet hours are two different HH:MM times on a known timezone
+func (q QuietHours) Validate() error {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return err
+	}
+
+	end, err := parseClock(q.End)
+	if err != nil {
+		return err
+	}
+
+	if start == end || q.Timezone == "" {
+		return ErrInvalidQuietHours
+	}
+
+	_, err = time.LoadLocation(q.Timezone)
+	if err != nil {
+		return ErrInvalidQuietHours
+	}
+
+	return nil
+}
+
+// EndAfter returns when the quiet hours are over if the time is inside them
+func (q QuietHours) EndAfter(now time.Time) (time.Time, bool) {
+	start, err := parseClock(q.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	end, err := parseClock(q.End)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	location, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	local := now.In(location)
+	minutes := local.Hour()*60 + local.Minute()
+
+	quiet := start <= minutes && minutes < end
+	if start > end {
+		quiet = minutes >= start || minutes < end
+	}
+
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	endsAt := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, location)
+	if !endsAt.After(local) {
+		endsAt = endsAt.AddDate(0, 0, 1)
+	}
+
+	return endsAt, true
+}
+
+// Validate checks the preferences only mention known channels and categories and that transactional
+// categories are not muted
+func (p Preferences) Validate() error {
+	for channel := range p.Channels {
+		if !containsChannel(channel) {
+			return ErrInvalidPreferences
+		}
+	}
+
+	for category, enabled := range p.Categories {
+		if !containsCategory(category) || (IsTransactional(category) && !enabled) {
+			return ErrInvalidPreferences
+		}
+	}
+
+	if p.QuietHours != nil {
+		return p.QuietHours.Validate()
+	}
+
+	return nil
+}
+
+// withDefaults returns the preferences with the channels and categories left out enabled
+func (p Preferences) withDefaults() Preferences {
+	preferences := DefaultPreferences()
+	for channel, enabled := range p.Channels {
+		preferences.Channels[channel] = enabled
+	}
+
+	for category, enabled := range p.Categories {
+		preferences.Categories[category] = enabled
+	}
+
+	preferences.QuietHours = p.QuietHours
+
+	return preferences
+}
+
+// Delivery tells if a notification of the category can be sent through the channel and when. Transactional
+// notifications are always sent right away, the rest wait for the quiet hours to end
+func (p Preferences) Delivery(channel Channel, category Category, now time.Time) (bool, time.Time) {
+	if IsTransactional(category) {
+		return true, now
+	}
+
+	if !p.Channels[channel] || !p.Categories[category] {
+		return false, time.Time{}
+	}
+
+	if p.QuietHours != nil {
+		if endsAt, quiet := p.QuietHours.EndAfter(now); quiet {
+			return true, endsAt
+		}
+	}
+
+	return true, now
+}
+
+func containsChannel(channel Channel) bool {
+	for _, known := range channels {
+		if known == channel {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsCategory(category Category) bool {
+	for _, known := range categories {
+		if known == category {
+			return true
+		}
+	}
+
+	return false
+}
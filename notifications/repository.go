@@ -0,0 +1,180 @@
+package notifications
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/CartechAPI/shared"
+	"github.com/lib/pq"
+)
+
+// deferredBatchSize is how many deferred notifications are delivered on each run
+const deferredBatchSize = 100
+
+// DeferredNotification is a notification waiting for the quiet hours of the client to end
+type DeferredNotification struct {
+	DeferredNotificationID int
+	ClientType             shared.ClientType
+	ClientID               int
+	Event                  Event
+	Params                 Params
+	DeliverAt              time.Time
+}
+
+// GetPreferences returns the preferences of the client, or the default ones if it never set them
+func GetPreferences(db *sql.DB, clientType shared.ClientType, clientID int) (Preferences, error) {
+	query := `SELECT push_enabled, sms_enabled, email_enabled, disabled_categories, quiet_start, quiet_end, quiet_timezone
+	FROM notification_preferences_table
+	WHERE client_type = $1 AND client_id = $2`
+
+	var push, sms, email bool
+	var disabledCategories []string
+	var quietStart, quietEnd, quietTimezone sql.NullString
+	err := db.QueryRow(query, clientType, clientID).Scan(&push, &sms, &email, pq.Array(&disabledCategories), &quietStart, &quietEnd, &quietTimezone)
+	if err == sql.ErrNoRows {
+		return DefaultPreferences(), nil
+	}
+
+	if err != nil {
+		log.Println("error selecting from notification_preferences_table: " + err.Error())
+		return Preferences{}, err
+	}
+
+	preferences := DefaultPreferences()
+	preferences.Channels[ChannelPush] = push
+	preferences.Channels[ChannelSMS] = sms
+	preferences.Channels[ChannelEmail] = email
+	for _, category := range disabledCategories {
+		preferences.Categories[Category(category)] = false
+	}
+
+	if quietStart.Valid && quietEnd.Valid && quietTimezone.Valid {
+		preferences.QuietHours = &QuietHours{Start: quietStart.String, End: quietEnd.String, Timezone: quietTimezone.String}
+	}
+
+	return preferences, nil
+}
+
+// SavePreferences replaces the preferences of the client
+func SavePreferences(db *sql.DB, clientType shared.ClientType, clientID int, preferences Preferences) error {
+	query := `INSERT INTO notification_preferences_table
+	(client_type, client_id, push_enabled, sms_enabled, email_enabled, disabled_categories, quiet_start, quiet_end, quiet_timezone, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+	ON CONFLICT (client_type, client_id) DO UPDATE SET
+	push_enabled = $3, sms_enabled = $4, email_enabled = $5, disabled_categories = $6,
+	quiet_start = $7, quiet_end = $8, quiet_timezone = $9, updated_at = NOW()`
+
+	disabledCategories := []string{}
+	for _, category := range categories {
+		if !preferences.Categories[category] {
+			disabledCategories = append(disabledCategories, string(category))
+		}
+	}
+
+	var quietStart, quietEnd, quietTimezone sql.NullString
+	if preferences.QuietHours != nil {
+		quietStart = sql.NullString{String: preferences.QuietHours.Start, Valid: true}
+		quietEnd = sql.NullString{String: preferences.QuietHours.End, Valid: true}
+		quietTimezone = sql.NullString{String: preferences.QuietHours.Timezone, Valid: true}
+	}
+
+	_, err := db.Exec(query, clientType, clientID, preferences.Channels[ChannelPush], preferences.Channels[ChannelSMS],
+		preferences.Channels[ChannelEmail], pq.Array(disabledCategories), quietStart, quietEnd, quietTimezone)
+	if err != nil {
+		log.Println("error inserting into notification_preferences_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func insertDeferredNotification(db *sql.DB, notification DeferredNotification) error {
+	query := `INSERT INTO deferred_notification_table (client_type, client_id, event, params, deliver_at, created_at)
+	VALUES ($1, $2, $3, $4, $5, NOW())`
+
+	params, err := json.Marshal(notification.Params)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(query, notification.ClientType, notification.ClientID, notification.Event, params, notification.DeliverAt)
+	if err != nil {
+		log.Println("error inserting into deferred_notification_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// selectDueNotifications locks a batch of the deferred notifications whose quiet hours are over
+func selectDueNotifications(tx *sql.Tx, now time.Time) ([]DeferredNotification, error) {
+	// skip locked lets several deliveries run at the same time without sending the same notification twice
+	query := `SELECT deferred_notification_id, client_type, client_id, event, params, deliver_at
+	FROM deferred_notification_table
+	WHERE deliver_at <= $1
+	ORDER BY deliver_at
+	LIMIT $2
+	FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.Query(query, now, deferredBatchSize)
+	if err != nil {
+		log.Println("error selecting from deferred_notification_table: " + err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	notifications := []DeferredNotification{}
+	for rows.Next() {
+		notification := DeferredNotification{}
+		var params []byte
+		err = rows.Scan(&notification.DeferredNotificationID, &notification.ClientType, &notification.ClientID, &notification.Event, &params, &notification.DeliverAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(params, &notification.Params)
+		if err != nil {
+			return nil, err
+		}
+
+		notifications = append(notifications, notification)
+	}
+
+	return notifications, rows.Err()
+}
+
+func postponeDeferredNotification(tx *sql.Tx, deferredNotificationID int, deliverAt time.Time) error {
+	_, err := tx.Exec("UPDATE deferred_notification_table SET deliver_at = $2 WHERE deferred_notification_id = $1", deferredNotificationID, deliverAt)
+	if err != nil {
+		log.Println("error updating deferred_notification_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+func deleteDeferredNotification(tx *sql.Tx, deferredNotificationID int) error {
+	_, err := tx.Exec("DELETE FROM deferred_notification_table WHERE deferred_notification_id = $1", deferredNotificationID)
+	if err != nil {
+		log.Println("error deleting from deferred_notification_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// DeleteClientsNotifications deletes the preferences and the pending notifications of the clients
+func DeleteClientsNotifications(tx *sql.Tx, clientType shared.ClientType, clientIDs []int64) error {
+	for _, table := range []string{"notification_preferences_table", "deferred_notification_table"} {
+		_, err := tx.Exec("DELETE FROM "+table+" WHERE client_type = $1 AND client_id = ANY($2)", clientType, pq.Array(clientIDs))
+		if err != nil {
+			log.Println("error deleting from " + table + ": " + err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package notifications
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/CartechAPI/shared"
+)
+
+// Event is something the clients are notified about
+type Event string
+
+const (
+	// EventNewOrder a mechanic is offered a new order
+	EventNewOrder Event = "new_order"
+	// EventOrderAssigned a mechanic accepted the order of the user
+	EventOrderAssigned Event = "order_assigned"
+	// EventMechanicAssigned the staff assigned a mechanic to the order of the user
+	EventMechanicAssigned Event = "mechanic_assigned"
+	// EventOrderFinished the mechanic finished the order of the user
+	EventOrderFinished Event = "order_finished"
+	// EventOrderFailed the mechanic could not complete the order of the user
+	EventOrderFailed Event = "order_failed"
+	// EventOrderCancelled the order of the user was cancelled
+	EventOrderCancelled Event = "order_cancelled"
+	// EventOrderCancelledByUser the user cancelled the order of the mechanic
+	EventOrderCancelledByUser Event = "order_cancelled_by_user"
+	// EventPromotion a promotion of the platform, the message is written by the campaign
+	EventPromotion Event = "promotion"
+)
+
+// Params are the values of the placeholders of a template, like service_name, mechanic_name or eta
+type Params map[string]string
+
+var (
+	// ErrUnknownEvent there is no template for the event
+	ErrUnknownEvent = errors.New("unknown notification event")
+	// ErrMissingParam a placeholder of the template has no value
+	ErrMissingParam = errors.New("missing notification param")
+)
+
+var placeholderRegexp = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// templates holds the title and body of every event per locale, placeholders are written as {name}
+var templates = map[Event]map[shared.Locale]Notification{
+	EventNewOrder: {
+		shared.LocaleSpanish: {Title: "¡Nueva orden!", Body: "Hay una nueva orden de {service_name} a {distance} km"},
+		shared.LocaleEnglish: {Title: "New order!", Body: "There is a new {service_name} order {distance} km away"},
+	},
+	EventOrderAssigned: {
+		shared.LocaleSpanish: {Title: "Un mecanico ha tomado tu orden", Body: "{mechanic_name} tomo tu orden de {service_name} y llegara en aproximadamente {eta} minutos"},
+		shared.LocaleEnglish: {Title: "A mechanic took your order", Body: "{mechanic_name} took your {service_name} order and will arrive in about {eta} minutes"},
+	},
+	EventMechanicAssigned: {
+		shared.LocaleSpanish: {Title: "Un mecanico ha tomado tu orden", Body: "{mechanic_name} fue asignado a tu orden de {service_name} y pronto estara iniciando"},
+		shared.LocaleEnglish: {Title: "A mechanic took your order", Body: "{mechanic_name} was assigned to your {service_name} order and will start soon"},
+	},
+	EventOrderFinished: {
+		shared.LocaleSpanish: {Title: "Tu orden ha finalizado", Body: "{mechanic_name} termino tu orden de {service_name}"},
+		shared.LocaleEnglish: {Title: "Your order is done", Body: "{mechanic_name} finished your {service_name} order"},
+	},
+	EventOrderFailed: {
+		shared.LocaleSpanish: {Title: "Tu orden no pudo completarse", Body: "{mechanic_name} no pudo completar tu orden de {service_name}"},
+		shared.LocaleEnglish: {Title: "Your order could not be completed", Body: "{mechanic_name} could not complete your {service_name} order"},
+	},
+	EventOrderCancelled: {
+		shared.LocaleSpanish: {Title: "Tu orden fue cancelada", Body: "Tu orden de {service_name} fue cancelada"},
+		shared.LocaleEnglish: {Title: "Your order was cancelled", Body: "Your {service_name} order was cancelled"},
+	},
+	EventOrderCancelledByUser: {
+		shared.LocaleSpanish: {Title: "Orden cancelada", Body: "El cliente cancelo la orden de {service_name}"},
+		shared.LocaleEnglish: {Title: "Order cancelled", Body: "The customer cancelled the {service_name} order"},
+	},
+	EventPromotion: {
+		shared.LocaleSpanish: {Title: "{title}", Body: "{message}"},
+		shared.LocaleEnglish: {Title: "{title}", Body: "{message}"},
+	},
+}
+
+// Render returns the notification of the event in the locale, falling back to the default locale
+func Render(event Event, locale shared.Locale, params Params) (Notification, error) {
+	variants, ok := templates[event]
+	if !ok {
+		return Notification{}, ErrUnknownEvent
+	}
+
+	template, ok := variants[locale]
+	if !ok {
+		template = variants[shared.DefaultLocale]
+	}
+
+	replacements := make([]string, 0, len(params)*2)
+	for name, value := range params {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+
+	replacer := strings.NewReplacer(replacements...)
+	notification := Notification{
+		Title: replacer.Replace(template.Title),
+		Body:  replacer.Replace(template.Body),
+	}
+
+	if placeholderRegexp.MatchString(notification.Title) || placeholderRegexp.MatchString(notification.Body) {
+		return Notification{}, ErrMissingParam
+	}
+
+	return notification, nil
+}
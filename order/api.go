@@ -8,19 +8,18 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/CartechAPI/admin"
 	"github.com/CartechAPI/auth"
 	"github.com/CartechAPI/shared"
 	"github.com/CartechAPI/utils"
 	"github.com/gorilla/mux"
-	"github.com/streadway/amqp"
 )
 
 // CreateServiceOrder receives the request to create a service request
-func CreateServiceOrder(db *sql.DB, channel *amqp.Channel) http.HandlerFunc {
+func CreateServiceOrder(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		serviceOrder := &ServiceOrder{}
-		// TODO: shouldnt we use the user id from the jwt?
-		_, _, err := auth.UserAuthenticationMiddleware(r)
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
 		if err != nil {
 			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
 			return
@@ -32,7 +31,7 @@ func CreateServiceOrder(db *sql.DB, channel *amqp.Channel) http.HandlerFunc {
 			return
 		}
 
-		serviceOrder, err = createServiceOrder(db, channel, serviceOrder)
+		serviceOrder, err = createServiceOrder(db, serviceOrder, Actor{Type: clientType, ID: clientID})
 		if err, ok := err.(shared.PublicError); ok {
 			showableError := err.(shared.ShowableError)
 			utils.RespondWithError(w, showableError.StatusCode, showableError.Message)
@@ -51,15 +50,26 @@ func CreateServiceOrder(db *sql.DB, channel *amqp.Channel) http.HandlerFunc {
 // GetAllServiceOrders handles the request for getting all service orders
 func GetAllServiceOrders(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("Authorization")
-		if token == "" {
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
 			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
 			return
 		}
 
 		status := r.URL.Query().Get("status")
 
-		serviceOrders, err := getAllServiceOrders(db, token, status)
+		limit, offset, err := admin.Pagination(r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		serviceOrders, err := getAllServiceOrders(db, Actor{Type: clientType, ID: clientID}, status, limit, offset)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
 		if err != nil {
 			log.Println(err.Error())
 			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
@@ -73,7 +83,7 @@ func GetAllServiceOrders(db *sql.DB) http.HandlerFunc {
 // GetAllPastServiceOrders returns the past service orders
 func GetAllPastServiceOrders(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		clientType, id, err := auth.UserAuthenticationMiddleware(r)
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
 		if err != nil {
 			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
 			return
@@ -92,7 +102,7 @@ func GetAllPastServiceOrders(db *sql.DB) http.HandlerFunc {
 // GetAllCurrentOrders handles the request for getting all current orders
 func GetAllCurrentOrders(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		clientType, id, err := auth.UserAuthenticationMiddleware(r)
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
 		if err != nil {
 			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
 			return
@@ -111,15 +121,21 @@ func GetAllCurrentOrders(db *sql.DB) http.HandlerFunc {
 // GetServiceOrder handles the request of a GET to a specific service order
 func GetServiceOrder(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
 		params := mux.Vars(r)
 		serviceOrderID, err := strconv.Atoi(params["order_id"])
 		if err != nil {
 			utils.RespondWithError(w, http.StatusBadRequest, "invalid request param")
 			return
 		}
-		serviceOrder, err := getServiceOrderByID(db, serviceOrderID)
+		serviceOrder, err := getServiceOrder(db, serviceOrderID, Actor{Type: clientType, ID: clientID})
 		if showableErr, ok := err.(shared.ShowableError); ok {
-			utils.RespondWithError(w, showableErr.StatusCode, "resource not found")
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
 			return
 		}
 
@@ -151,8 +167,14 @@ func validatePatchRequestBodyFields(patchRequest shared.PatchRequestBody) error
 }
 
 // UpdateServiceOrder handles the request of updating a service order
-func UpdateServiceOrder(db *sql.DB) http.HandlerFunc {
+func UpdateServiceOrder(db *sql.DB, dispatcher *EventDispatcher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
 		params := mux.Vars(r)
 		serviceOrderID, err := strconv.Atoi(params["order_id"])
 		if err != nil {
@@ -173,7 +195,7 @@ func UpdateServiceOrder(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		err = updateServiceOrder(db, serviceOrderID, patchRequest)
+		err = updateServiceOrder(db, dispatcher, serviceOrderID, patchRequest, Actor{Type: clientType, ID: clientID})
 		if showableError, ok := err.(shared.ShowableError); ok {
 			utils.RespondWithError(w, showableError.StatusCode, showableError.Message)
 			return
@@ -187,8 +209,14 @@ func UpdateServiceOrder(db *sql.DB) http.HandlerFunc {
 }
 
 // AssignMechanicToOrder assings a mechanic to an order
-func AssignMechanicToOrder(db *sql.DB) http.HandlerFunc {
+func AssignMechanicToOrder(db *sql.DB, dispatcher *EventDispatcher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
 		mechanicIDS := r.URL.Query().Get("mechanic_id")
 		if mechanicIDS == "" {
 			utils.RespondWithError(w, http.StatusBadRequest, "missing mechanic id")
@@ -208,7 +236,12 @@ func AssignMechanicToOrder(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		err = assignMechanicToOrder(db, mechanicID, orderID)
+		err = assignMechanicToOrder(db, dispatcher, mechanicID, orderID, Actor{Type: clientType, ID: clientID})
+		if showableError, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableError.StatusCode, showableError.Message)
+			return
+		}
+
 		if err != nil {
 			log.Println(err.Error())
 			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
@@ -218,3 +251,118 @@ func AssignMechanicToOrder(db *sql.DB) http.HandlerFunc {
 		utils.RespondJSON(w, 200, "ok")
 	}
 }
+
+// GetServiceOrderEvents handles the request for getting the event history of a service order
+func GetServiceOrderEvents(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		params := mux.Vars(r)
+		serviceOrderID, err := strconv.Atoi(params["order_id"])
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request param")
+			return
+		}
+
+		events, err := getServiceOrderEvents(db, serviceOrderID, Actor{Type: clientType, ID: clientID})
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"events": events})
+	}
+}
+
+// GetOffers handles the request of a mechanic for listing the offers it can still answer
+func GetOffers(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		offers, err := getOpenOffers(db, Actor{Type: clientType, ID: clientID})
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, map[string]interface{}{"offers": offers})
+	}
+}
+
+// AcceptOffer handles the request of a mechanic for taking the order of an offer
+func AcceptOffer(db *sql.DB, dispatcher *EventDispatcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		offerID, err := strconv.Atoi(mux.Vars(r)["offer_id"])
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request param")
+			return
+		}
+
+		serviceOrder, err := acceptOffer(db, dispatcher, offerID, Actor{Type: clientType, ID: clientID})
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, serviceOrder)
+	}
+}
+
+// DeclineOffer handles the request of a mechanic for rejecting an offer
+func DeclineOffer(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, clientID, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		offerID, err := strconv.Atoi(mux.Vars(r)["offer_id"])
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request param")
+			return
+		}
+
+		err = declineOffer(db, offerID, Actor{Type: clientType, ID: clientID})
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
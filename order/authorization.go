@@ -0,0 +1,63 @@
+package order
+
+import (
+	"net/http"
+
+	"github.com/CartechAPI/shared"
+)
+
+var (
+	// ErrForbidden client is not allowed to access the order
+	ErrForbidden = shared.NewShowableError("client is not allowed to perform the request", http.StatusForbidden)
+)
+
+// userAllowedStatus are the statuses a user can move its own order to
+var userAllowedStatus = map[ServiceOrderStatus]bool{
+	ServiceOrderStatusCancelled: true,
+}
+
+// mechanicAllowedStatus are the statuses a mechanic can move an order assigned to it to
+var mechanicAllowedStatus = map[ServiceOrderStatus]bool{
+	ServiceOrderStatusFinished:  true,
+	ServiceOrderStatusFailure:   true,
+	ServiceOrderStatusCancelled: true,
+}
+
+func isOrderOpen(order ServiceOrder) bool {
+	return order.Status == ServiceOrderStatusPending && order.MechanicID == 0
+}
+
+func canReadServiceOrder(actor Actor, order ServiceOrder) bool {
+	switch actor.Type {
+	case shared.ClientTypeAdmin:
+		return true
+	case shared.ClientTypeUser:
+		return order.UserID == actor.ID
+	case shared.ClientTypeMechanic:
+		return order.MechanicID == actor.ID || isOrderOpen(order)
+	}
+
+	return false
+}
+
+func canChangeServiceOrderStatus(actor Actor, order ServiceOrder, status ServiceOrderStatus) bool {
+	switch actor.Type {
+	case shared.ClientTypeAdmin:
+		return true
+	case shared.ClientTypeUser:
+		return order.UserID == actor.ID && userAllowedStatus[status]
+	case shared.ClientTypeMechanic:
+		return order.MechanicID == actor.ID && mechanicAllowedStatus[status]
+	}
+
+	return false
+}
+
+// canAssignMechanicToOrder tells if the actor can assign a mechanic directly, mechanics take orders by accepting offers
+func canAssignMechanicToOrder(actor Actor) bool {
+	return actor.Type == shared.ClientTypeAdmin
+}
+
+func canCreateServiceOrder(actor Actor) bool {
+	return actor.Type == shared.ClientTypeUser
+}
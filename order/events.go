@@ -0,0 +1,55 @@
+package order
+
+import (
+	"database/sql"
+	"log"
+)
+
+// EventHandler reacts to an event recorded on a service order, the order is read after the change was committed
+type EventHandler func(event OrderEvent, order ServiceOrder) error
+
+// EventDispatcher calls the handlers registered for the events recorded on the service orders.
+// Handlers run after the change was committed, so a failing handler never undoes the change
+type EventDispatcher struct {
+	handlers map[OrderEventType][]EventHandler
+}
+
+// NewEventDispatcher returns a dispatcher without handlers
+func NewEventDispatcher() *EventDispatcher {
+	return &EventDispatcher{handlers: map[OrderEventType][]EventHandler{}}
+}
+
+// On registers the handler for the events of the type, handlers are called in the order they were registered
+func (d *EventDispatcher) On(eventType OrderEventType, handler EventHandler) {
+	d.handlers[eventType] = append(d.handlers[eventType], handler)
+}
+
+// Dispatch calls the handlers of every event, the errors are logged since the change already happened
+func (d *EventDispatcher) Dispatch(events []OrderEvent, order ServiceOrder) {
+	if d == nil {
+		return
+	}
+
+	for _, event := range events {
+		for _, handler := range d.handlers[event.EventType] {
+			err := handler(event, order)
+			if err != nil {
+				log.Println("error handling order event " + string(event.EventType) + ": " + err.Error())
+			}
+		}
+	}
+}
+
+// dispatchEvents reads the order as it is after the change and dispatches the events recorded on it. The change
+// is already committed, so if the order can not be read again the expected state is used instead of failing
+func dispatchEvents(db *sql.DB, dispatcher *EventDispatcher, expected ServiceOrder, events []OrderEvent) *ServiceOrder {
+	order, err := getServiceOrderByID(db, expected.ServiceOrderID)
+	if err != nil {
+		log.Println("error reading order after its change: " + err.Error())
+		order = &expected
+	}
+
+	dispatcher.Dispatch(events, *order)
+
+	return order
+}
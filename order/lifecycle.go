@@ -0,0 +1,111 @@
+package order
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/CartechAPI/dispatch"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/notifications"
+	"github.com/CartechAPI/service"
+	"github.com/CartechAPI/shared"
+)
+
+// statusNotifications holds what the user is told when its order enters a status
+var statusNotifications = map[ServiceOrderStatus]notifications.Event{
+	ServiceOrderStatusFinished: notifications.EventOrderFinished,
+	ServiceOrderStatusFailure:  notifications.EventOrderFailed,
+}
+
+// RegisterNotificationHandlers notifies the user and the mechanic of the order when its lifecycle changes
+func RegisterNotificationHandlers(dispatcher *EventDispatcher, db *sql.DB, notifier notifications.Notifier) {
+	dispatcher.On(OrderEventTypeStatusChanged, func(event OrderEvent, order ServiceOrder) error {
+		return notifyStatusChanged(db, notifier, event, order)
+	})
+
+	dispatcher.On(OrderEventTypeCancelled, func(event OrderEvent, order ServiceOrder) error {
+		return notifyCancelled(db, notifier, event, order)
+	})
+}
+
+func notifyStatusChanged(db *sql.DB, notifier notifications.Notifier, event OrderEvent, order ServiceOrder) error {
+	status := ServiceOrderStatus(event.NewValue)
+	if status == ServiceOrderStatusInProgress {
+		return notifyMechanicAssigned(db, notifier, order)
+	}
+
+	notificationEvent, ok := statusNotifications[status]
+	if !ok {
+		return nil
+	}
+
+	params, err := orderNotificationParams(db, order)
+	if err != nil {
+		return err
+	}
+
+	return notifications.NotifyClient(db, notifier, shared.ClientTypeUser, order.UserID, notificationEvent, params)
+}
+
+// notifyMechanicAssigned tells the user who took its order, the arrival can only be estimated if the mechanic
+// reported where it is
+func notifyMechanicAssigned(db *sql.DB, notifier notifications.Notifier, order ServiceOrder) error {
+	params, err := orderNotificationParams(db, order)
+	if err != nil {
+		return err
+	}
+
+	notificationEvent := notifications.EventMechanicAssigned
+	location, err := mec.GetMechanicLocation(db, order.MechanicID)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	if err == nil {
+		distanceKm := dispatch.Distance(location.Lat, location.Lng, order.Lat, order.Lng)
+		params["eta"] = strconv.Itoa(dispatch.EstimateArrival(distanceKm))
+		notificationEvent = notifications.EventOrderAssigned
+	}
+
+	return notifications.NotifyClient(db, notifier, shared.ClientTypeUser, order.UserID, notificationEvent, params)
+}
+
+// notifyCancelled tells the mechanic when the user cancels and the user when anyone else does
+func notifyCancelled(db *sql.DB, notifier notifications.Notifier, event OrderEvent, order ServiceOrder) error {
+	if event.ActorType == shared.ClientTypeUser && order.MechanicID == 0 {
+		return nil
+	}
+
+	params, err := orderNotificationParams(db, order)
+	if err != nil {
+		return err
+	}
+
+	if event.ActorType == shared.ClientTypeUser {
+		return notifications.NotifyClient(db, notifier, shared.ClientTypeMechanic, order.MechanicID, notifications.EventOrderCancelledByUser, params)
+	}
+
+	return notifications.NotifyClient(db, notifier, shared.ClientTypeUser, order.UserID, notifications.EventOrderCancelled, params)
+}
+
+// orderNotificationParams returns the params describing the order, the mechanic name is only set once one took it
+func orderNotificationParams(db *sql.DB, order ServiceOrder) (notifications.Params, error) {
+	svc, err := service.GetServiceByID(db, order.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	params := notifications.Params{"service_name": svc.ServiceName}
+	if order.MechanicID == 0 {
+		return params, nil
+	}
+
+	mechanic, err := mec.GetMechanicByID(db, order.MechanicID)
+	if err != nil {
+		return nil, err
+	}
+
+	params["mechanic_name"] = mechanic.Name + " " + mechanic.LastName
+
+	return params, nil
+}
@@ -1,6 +1,10 @@
 package order
 
-import "time"
+import (
+	"time"
+
+	"github.com/CartechAPI/shared"
+)
 
 // ServiceOrderStatus is the status of a service order
 type ServiceOrderStatus string
@@ -34,6 +38,36 @@ type ServiceOrder struct {
 	Lng            float64            `json:"lng"`
 }
 
+// OrderEventType is the type of an event on a service order
+type OrderEventType string
+
+const (
+	// OrderEventTypeStatusChanged the order status changed
+	OrderEventTypeStatusChanged OrderEventType = "status_changed"
+	// OrderEventTypeMechanicAssigned a mechanic was assigned to the order
+	OrderEventTypeMechanicAssigned OrderEventType = "mechanic_assigned"
+	// OrderEventTypeCancelled the order was cancelled
+	OrderEventTypeCancelled OrderEventType = "cancelled"
+)
+
+// OrderEvent represents something that happened to a service order
+type OrderEvent struct {
+	OrderEventID   int               `json:"order_event_id"`
+	ServiceOrderID int               `json:"service_order_id"`
+	EventType      OrderEventType    `json:"event_type"`
+	ActorType      shared.ClientType `json:"actor_type"`
+	ActorID        int               `json:"actor_id"`
+	OldValue       string            `json:"old_value"`
+	NewValue       string            `json:"new_value"`
+	CreatedAt      time.Time         `json:"created_at"`
+}
+
+// Actor is the client performing an action on a service order
+type Actor struct {
+	Type shared.ClientType
+	ID   int
+}
+
 var ValidServiceOrderStatus = map[ServiceOrderStatus]bool{
 	ServiceOrderStatusCancelled:  true,
 	ServiceOrderStatusFailure:    true,
@@ -41,3 +75,17 @@ var ValidServiceOrderStatus = map[ServiceOrderStatus]bool{
 	ServiceOrderStatusPending:    true,
 	ServiceOrderStatusInProgress: true,
 }
+
+// serviceOrderStatusTransitions holds the statuses each status is allowed to move to
+var serviceOrderStatusTransitions = map[ServiceOrderStatus][]ServiceOrderStatus{
+	ServiceOrderStatusPending:    {ServiceOrderStatusInProgress, ServiceOrderStatusCancelled},
+	ServiceOrderStatusInProgress: {ServiceOrderStatusFinished, ServiceOrderStatusFailure, ServiceOrderStatusCancelled},
+}
+
+// serviceOrderStatusTimestamps holds the column stamped when an order enters a status
+var serviceOrderStatusTimestamps = map[ServiceOrderStatus]string{
+	ServiceOrderStatusInProgress: "started_at",
+	ServiceOrderStatusFinished:   "finished_at",
+	ServiceOrderStatusFailure:    "finished_at",
+	ServiceOrderStatusCancelled:  "cancelled_at",
+}
@@ -0,0 +1,170 @@
+package order
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/CartechAPI/auth"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/shared"
+)
+
+// OfferStatus is the status of an offer
+type OfferStatus string
+
+const (
+	// OfferStatusPending the mechanic has not answered yet
+	OfferStatusPending OfferStatus = "pending"
+	// OfferStatusAccepted the mechanic took the order
+	OfferStatusAccepted OfferStatus = "accepted"
+	// OfferStatusDeclined the mechanic rejected the order
+	OfferStatusDeclined OfferStatus = "declined"
+	// OfferStatusExpired the mechanic did not answer in time or another mechanic took the order
+	OfferStatusExpired OfferStatus = "expired"
+)
+
+// Offer is a time limited proposal to a mechanic for taking a service order
+type Offer struct {
+	OfferID        int         `json:"offer_id"`
+	ServiceOrderID int         `json:"service_order_id"`
+	MechanicID     int         `json:"mechanic_id"`
+	Status         OfferStatus `json:"status"`
+	DistanceKm     float64     `json:"distance_km"`
+	CreatedAt      time.Time   `json:"created_at"`
+	ExpiresAt      time.Time   `json:"expires_at"`
+	RespondedAt    *time.Time  `json:"responded_at,omitempty"`
+}
+
+var (
+	// ErrOfferNotFound offer not found
+	ErrOfferNotFound = shared.NewShowableError("resource not found", http.StatusNotFound)
+	// ErrOfferNotAvailable the offer was already answered or expired
+	ErrOfferNotAvailable = shared.NewShowableError("offer is no longer available", http.StatusConflict)
+	// ErrOrderAlreadyTaken another mechanic took the order first
+	ErrOrderAlreadyTaken = shared.NewShowableError("order was already taken", http.StatusConflict)
+)
+
+// IsOfferOpen tells if the mechanic can still answer the offer
+func IsOfferOpen(offer Offer, now time.Time) bool {
+	return offer.Status == OfferStatusPending && now.Before(offer.ExpiresAt)
+}
+
+// CreateOffer offers the order to the mechanic until the timeout
+func CreateOffer(db *sql.DB, serviceOrderID int, mechanicID int, distanceKm float64, timeout time.Duration) (*Offer, error) {
+	return insertOffer(db, Offer{
+		ServiceOrderID: serviceOrderID,
+		MechanicID:     mechanicID,
+		DistanceKm:     distanceKm,
+		ExpiresAt:      time.Now().Add(timeout),
+	})
+}
+
+// GetServiceOrderOffers returns every offer made for the order
+func GetServiceOrderOffers(db *sql.DB, serviceOrderID int) ([]Offer, error) {
+	return selectOrderOffers(db, serviceOrderID)
+}
+
+// ExpireOffers closes the pending offers that ran out of time
+func ExpireOffers(db *sql.DB) (int64, error) {
+	return updateExpiredOffers(db)
+}
+
+// GetOrdersAwaitingMechanic returns the ids of the pending orders created after the given time that have
+// less open offers than wanted, so they need to be offered to more mechanics
+func GetOrdersAwaitingMechanic(db *sql.DB, openOffers int, createdAfter time.Time) ([]int, error) {
+	return selectOrdersAwaitingMechanic(db, openOffers, createdAfter)
+}
+
+// getOwnOffer returns the offer if it was made to the mechanic, offers of other mechanics are reported as not found
+func getOwnOffer(db *sql.DB, offerID int, actor Actor) (*Offer, error) {
+	if actor.Type != shared.ClientTypeMechanic {
+		return nil, ErrForbidden
+	}
+
+	offer, err := selectOfferByID(db, offerID)
+	if err == sql.ErrNoRows {
+		return nil, ErrOfferNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if offer.MechanicID != actor.ID {
+		return nil, ErrOfferNotFound
+	}
+
+	return offer, nil
+}
+
+func getOpenOffers(db *sql.DB, actor Actor) ([]Offer, error) {
+	if actor.Type != shared.ClientTypeMechanic {
+		return nil, ErrForbidden
+	}
+
+	return selectMechanicOpenOffers(db, actor.ID)
+}
+
+func acceptOffer(db *sql.DB, dispatcher *EventDispatcher, offerID int, actor Actor) (*ServiceOrder, error) {
+	offer, err := getOwnOffer(db, offerID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsOfferOpen(*offer, time.Now()) {
+		return nil, ErrOfferNotAvailable
+	}
+
+	order, err := getServiceOrderByID(db, offer.ServiceOrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isOrderOpen(*order) {
+		return nil, ErrOrderAlreadyTaken
+	}
+
+	err = auth.CheckContactVerified(db, shared.ClientTypeMechanic, actor.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	offersService, err := mec.OffersService(db, actor.ID, order.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !offersService {
+		return nil, ErrMechanicLacksService
+	}
+
+	events, err := acceptOfferAndAssign(db, *offer, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	order.MechanicID = actor.ID
+	order.Status = ServiceOrderStatusInProgress
+
+	return dispatchEvents(db, dispatcher, *order, events), nil
+}
+
+// declineOffer rejects the offer, the assigner offers the order to the next mechanic on its next round
+func declineOffer(db *sql.DB, offerID int, actor Actor) error {
+	offer, err := getOwnOffer(db, offerID, actor)
+	if err != nil {
+		return err
+	}
+
+	declined, err := updateOfferDeclined(db, offer.OfferID)
+	if err != nil {
+		return err
+	}
+
+	if !declined {
+		return ErrOfferNotAvailable
+	}
+
+	return nil
+}
@@ -2,16 +2,15 @@ package order
 
 import (
 	"database/sql"
-	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/CartechAPI/auth"
-	"github.com/CartechAPI/notifications"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/queue"
 	"github.com/CartechAPI/shared"
-	"github.com/CartechAPI/utils"
-	"github.com/streadway/amqp"
 )
 
 var (
@@ -29,11 +28,24 @@ var (
 	ErrMultipleServiceOrders = shared.NewBadRequestError("user is not allowed to have more than one service")
 	// ErrInvalidStatus invalid status
 	ErrInvalidStatus = shared.NewBadRequestError("invalid status")
+	// ErrOrderNotFound order not found
+	ErrOrderNotFound = shared.NewShowableError("resource not found", http.StatusNotFound)
+	// ErrMechanicLacksService the mechanic does not offer the service of the order
+	ErrMechanicLacksService = shared.NewShowableError("mechanic does not offer the service of the order", http.StatusForbidden)
+	// ErrStartRequiresMechanic an order is only started by assigning it a mechanic
+	ErrStartRequiresMechanic = shared.NewShowableError("an order is started by assigning it a mechanic", http.StatusConflict)
 )
 
 // AssignerQueue assigner queue
 const AssignerQueue = "assign-order"
 
+// AssignerTopology is where new orders are published for the assigner, both sides declare it
+var AssignerTopology = queue.Topology{
+	Exchange:    "orders",
+	Queue:       AssignerQueue,
+	RetryDelays: []time.Duration{5 * time.Second, 30 * time.Second, 2 * time.Minute, 10 * time.Minute},
+}
+
 func validateServiceOrderFields(serviceOrder ServiceOrder) error {
 	if serviceOrder.UserID == 0 {
 		return ErrMissingUserID
@@ -54,8 +66,18 @@ func validateServiceOrderFields(serviceOrder ServiceOrder) error {
 	return nil
 }
 
-func createServiceOrder(db *sql.DB, channel *amqp.Channel, serviceOrder *ServiceOrder) (*ServiceOrder, error) {
-	err := validateServiceOrderFields(*serviceOrder)
+func createServiceOrder(db *sql.DB, serviceOrder *ServiceOrder, actor Actor) (*ServiceOrder, error) {
+	if !canCreateServiceOrder(actor) {
+		return nil, ErrForbidden
+	}
+
+	err := auth.CheckContactVerified(db, actor.Type, actor.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceOrder.UserID = actor.ID
+	err = validateServiceOrderFields(*serviceOrder)
 	if err != nil {
 		return nil, err
 	}
@@ -77,46 +99,16 @@ func createServiceOrder(db *sql.DB, channel *amqp.Channel, serviceOrder *Service
 
 	serviceOrder.ServiceOrderID = id
 
-	err = assignOrder(channel, *serviceOrder)
-	if err != nil {
-		return nil, err
-	}
-
 	return serviceOrder, nil
 }
 
-func assignOrder(channel *amqp.Channel, order ServiceOrder) error {
-	marshalledOrder, err := json.Marshal(order)
-	if err != nil {
-		log.Println("failed_to_marshall_order:" + err.Error())
-		return err
-	}
-
-	err = channel.Publish(
-		"",
-		AssignerQueue,
-		false,
-		false,
-		amqp.Publishing{
-			Body: marshalledOrder,
-		},
-	)
-
-	if err != nil {
-		log.Println("failed_to_publish_message_assign_order: " + err.Error())
-		return err
-	}
-
-	return nil
-}
-
-func updateServiceOrder(db *sql.DB, serviceOrderID int, patchRequest shared.PatchRequestBody) error {
+func updateServiceOrder(db *sql.DB, dispatcher *EventDispatcher, serviceOrderID int, patchRequest shared.PatchRequestBody, actor Actor) error {
 	for _, updateOp := range patchRequest {
 		if updateOp.Op == shared.PatchOpReplace {
-			err := replaceOnServiceOrder(db, serviceOrderID, updateOp.Path, updateOp.Value)
+			err := replaceOnServiceOrder(db, dispatcher, serviceOrderID, updateOp.Path, updateOp.Value, actor)
 			// TODO: what happens if the err occurs on the second or third updateOP? Should the message be specific on one updateOp?
 			if err == ErrNoRowsAffected {
-				return shared.NewShowableError("resource not found", http.StatusNotFound)
+				return ErrOrderNotFound
 			}
 
 			if err == ErrMissingNewValue {
@@ -132,15 +124,73 @@ func updateServiceOrder(db *sql.DB, serviceOrderID int, patchRequest shared.Patc
 	return nil
 }
 
-func replaceOnServiceOrder(db *sql.DB, serviceOrderID int, toReplace string, newValue string) error {
+func replaceOnServiceOrder(db *sql.DB, dispatcher *EventDispatcher, serviceOrderID int, toReplace string, newValue string, actor Actor) error {
 	if newValue == "" {
 		return ErrMissingNewValue
 	}
 
 	if toReplace == "status" {
-		return updateServiceOrderStatus(db, serviceOrderID, ServiceOrderStatus(newValue))
+		return changeServiceOrderStatus(db, dispatcher, serviceOrderID, ServiceOrderStatus(newValue), actor)
+	}
+
+	return nil
+}
+
+func newInvalidStatusTransitionError(from ServiceOrderStatus, to ServiceOrderStatus) error {
+	return shared.NewShowableError(fmt.Sprintf("order can not go from %s to %s", from, to), http.StatusConflict)
+}
+
+func canTransitionServiceOrderStatus(from ServiceOrderStatus, to ServiceOrderStatus) bool {
+	for _, status := range serviceOrderStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isServiceOrderStatusFinal tells if an order in the status can not change anymore
+func isServiceOrderStatusFinal(status ServiceOrderStatus) bool {
+	return len(serviceOrderStatusTransitions[status]) == 0
+}
+
+func changeServiceOrderStatus(db *sql.DB, dispatcher *EventDispatcher, serviceOrderID int, status ServiceOrderStatus, actor Actor) error {
+	if !isServiceOrderStatusValid(status) {
+		return ErrInvalidStatus
+	}
+
+	// an order in progress must have a mechanic, which only assignMechanicToOrder and acceptOffer set
+	if status == ServiceOrderStatusInProgress {
+		return ErrStartRequiresMechanic
+	}
+
+	order, err := getServiceOrderByID(db, serviceOrderID)
+	if err != nil {
+		return err
+	}
+
+	if !canChangeServiceOrderStatus(actor, *order, status) {
+		return ErrForbidden
+	}
+
+	if !canTransitionServiceOrderStatus(order.Status, status) {
+		return newInvalidStatusTransitionError(order.Status, status)
+	}
+
+	events, err := updateServiceOrderStatus(db, serviceOrderID, order.Status, status, actor)
+	if err == ErrNoRowsAffected {
+		// the status changed between the read and the update
+		return newInvalidStatusTransitionError(order.Status, status)
+	}
+
+	if err != nil {
+		return err
 	}
 
+	order.Status = status
+	dispatchEvents(db, dispatcher, *order, events)
+
 	return nil
 }
 
@@ -152,7 +202,19 @@ func isServiceOrderStatusValid(status ServiceOrderStatus) bool {
 	return false
 }
 
-func getAllServiceOrders(db *sql.DB, token string, status string) ([]ServiceOrder, error) {
+func filterServiceOrdersByStatus(serviceOrders []ServiceOrder, status ServiceOrderStatus) []ServiceOrder {
+	filtered := []ServiceOrder{}
+	for _, serviceOrder := range serviceOrders {
+		if serviceOrder.Status == status {
+			filtered = append(filtered, serviceOrder)
+		}
+	}
+
+	return filtered
+}
+
+// getAllServiceOrders returns the orders the actor can see, admins see every order of the platform a page at a time
+func getAllServiceOrders(db *sql.DB, actor Actor, status string, limit int, offset int) ([]ServiceOrder, error) {
 	if !isServiceOrderStatusValid(ServiceOrderStatus(status)) && status != "" {
 		return nil, ErrInvalidStatus
 	}
@@ -160,33 +222,28 @@ func getAllServiceOrders(db *sql.DB, token string, status string) ([]ServiceOrde
 	var err error
 	serviceOrders := []ServiceOrder{}
 
-	if status != "" {
-		serviceOrders, err = selectAllOrdersByStatus(db, ServiceOrderStatus(status))
-		if err != nil {
-			return nil, err
+	switch actor.Type {
+	case shared.ClientTypeAdmin:
+		return selectOrdersPage(db, ServiceOrderStatus(status), limit, offset)
+	case shared.ClientTypeMechanic:
+		// mechanics can browse the open orders to take one
+		if ServiceOrderStatus(status) == ServiceOrderStatusPending {
+			return selectAllOrdersByStatus(db, ServiceOrderStatusPending)
 		}
 
-		return serviceOrders, nil
+		serviceOrders, err = selectAllOrdersFromMechanic(db, actor.ID)
+	case shared.ClientTypeUser:
+		serviceOrders, err = selectAllOrdersFromUser(db, actor.ID)
+	default:
+		return nil, ErrForbidden
 	}
 
-	// TODO: move this to another function to filter by user
-	clientType, id, err := utils.DecodeToken(token)
 	if err != nil {
 		return nil, err
 	}
 
-	if clientType == shared.ClientTypeUser {
-		serviceOrders, err = selectAllOrdersFromUser(db, id)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	if clientType == shared.ClientTypeAdmin {
-		serviceOrders, err = selectAllOrdersFromMechanic(db, id)
-		if err != nil {
-			return nil, err
-		}
+	if status != "" {
+		return filterServiceOrdersByStatus(serviceOrders, ServiceOrderStatus(status)), nil
 	}
 
 	return serviceOrders, nil
@@ -218,26 +275,86 @@ func getAllCurrentOrders(db *sql.DB, clientType shared.ClientType, id int) ([]Se
 	return nil, errors.New("not yet implemented")
 }
 
-func assignMechanicToOrder(db *sql.DB, mechanicID int, orderID int) error {
-	err := setOrderMechanic(db, orderID, mechanicID)
+func assignMechanicToOrder(db *sql.DB, dispatcher *EventDispatcher, mechanicID int, orderID int, actor Actor) error {
+	// authorized first so the response does not tell a non admin whether the order exists or which status it has
+	if !canAssignMechanicToOrder(actor) {
+		return ErrForbidden
+	}
+
+	order, err := getServiceOrderByID(db, orderID)
 	if err != nil {
 		return err
 	}
 
-	order, err := getServiceOrderByID(db, orderID)
+	if !canTransitionServiceOrderStatus(order.Status, ServiceOrderStatusInProgress) {
+		return newInvalidStatusTransitionError(order.Status, ServiceOrderStatusInProgress)
+	}
+
+	err = auth.CheckContactVerified(db, shared.ClientTypeMechanic, mechanicID)
 	if err != nil {
 		return err
 	}
 
-	session, err := auth.GetLastSession(db, order.UserID)
+	offersService, err := mec.OffersService(db, mechanicID, order.ServiceID)
 	if err != nil {
 		return err
 	}
 
-	err = notifications.SendNotificationToSingleUser(session.Token, "Un mecanico ha tomado tu orden", "Tu orden ha sido tomada por un mecanico y pronto estara iniciando")
+	if !offersService {
+		return ErrMechanicLacksService
+	}
+
+	events, err := setOrderMechanic(db, orderID, mechanicID, actor)
+	if err == ErrNoRowsAffected {
+		return newInvalidStatusTransitionError(order.Status, ServiceOrderStatusInProgress)
+	}
+
 	if err != nil {
 		return err
 	}
 
+	order.MechanicID = mechanicID
+	order.Status = ServiceOrderStatusInProgress
+	dispatchEvents(db, dispatcher, *order, events)
+
 	return nil
 }
+
+func getServiceOrder(db *sql.DB, serviceOrderID int, actor Actor) (*ServiceOrder, error) {
+	order, err := getServiceOrderByID(db, serviceOrderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canReadServiceOrder(actor, *order) {
+		return nil, ErrForbidden
+	}
+
+	return order, nil
+}
+
+func getServiceOrderEvents(db *sql.DB, serviceOrderID int, actor Actor) ([]OrderEvent, error) {
+	_, err := getServiceOrder(db, serviceOrderID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return selectOrderEvents(db, serviceOrderID)
+}
+
+// GetServiceOrderByID returns the service order without checking who is asking for it
+func GetServiceOrderByID(db *sql.DB, serviceOrderID int) (*ServiceOrder, error) {
+	return getServiceOrderByID(db, serviceOrderID)
+}
+
+// GetClientServiceOrders returns every service order of the user or mechanic
+func GetClientServiceOrders(db *sql.DB, clientType shared.ClientType, id int) ([]ServiceOrder, error) {
+	switch clientType {
+	case shared.ClientTypeUser:
+		return selectAllOrdersFromUser(db, id)
+	case shared.ClientTypeMechanic:
+		return selectAllOrdersFromMechanic(db, id)
+	}
+
+	return []ServiceOrder{}, nil
+}
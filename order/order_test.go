@@ -1,8 +1,12 @@
 package order
 
 import (
+	"errors"
 	"testing"
+	"time"
 
+	"github.com/CartechAPI/notifications"
+	"github.com/CartechAPI/shared"
 	"github.com/stretchr/testify/require"
 )
 
@@ -12,3 +16,128 @@ func TestIsServiceOrderStatusValid(t *testing.T) {
 	c.True(isServiceOrderStatusValid(ServiceOrderStatus("pending")))
 	c.False(isServiceOrderStatusValid(ServiceOrderStatus("anotherstatus")))
 }
+
+func TestCanTransitionServiceOrderStatus(t *testing.T) {
+	c := require.New(t)
+
+	c.True(canTransitionServiceOrderStatus(ServiceOrderStatusPending, ServiceOrderStatusInProgress))
+	c.True(canTransitionServiceOrderStatus(ServiceOrderStatusPending, ServiceOrderStatusCancelled))
+	c.True(canTransitionServiceOrderStatus(ServiceOrderStatusInProgress, ServiceOrderStatusFinished))
+	c.True(canTransitionServiceOrderStatus(ServiceOrderStatusInProgress, ServiceOrderStatusFailure))
+	c.True(canTransitionServiceOrderStatus(ServiceOrderStatusInProgress, ServiceOrderStatusCancelled))
+
+	c.False(canTransitionServiceOrderStatus(ServiceOrderStatusPending, ServiceOrderStatusFinished))
+	c.False(canTransitionServiceOrderStatus(ServiceOrderStatusFinished, ServiceOrderStatusPending))
+	c.False(canTransitionServiceOrderStatus(ServiceOrderStatusCancelled, ServiceOrderStatusInProgress))
+	c.False(canTransitionServiceOrderStatus(ServiceOrderStatusInProgress, ServiceOrderStatusPending))
+}
+
+func TestIsServiceOrderStatusFinal(t *testing.T) {
+	c := require.New(t)
+
+	c.True(isServiceOrderStatusFinal(ServiceOrderStatusFinished))
+	c.True(isServiceOrderStatusFinal(ServiceOrderStatusFailure))
+	c.True(isServiceOrderStatusFinal(ServiceOrderStatusCancelled))
+	c.False(isServiceOrderStatusFinal(ServiceOrderStatusPending))
+	c.False(isServiceOrderStatusFinal(ServiceOrderStatusInProgress))
+}
+
+func TestCanReadServiceOrder(t *testing.T) {
+	c := require.New(t)
+
+	order := ServiceOrder{UserID: 1, MechanicID: 2, Status: ServiceOrderStatusInProgress}
+	openOrder := ServiceOrder{UserID: 1, Status: ServiceOrderStatusPending}
+
+	c.True(canReadServiceOrder(Actor{Type: shared.ClientTypeUser, ID: 1}, order))
+	c.False(canReadServiceOrder(Actor{Type: shared.ClientTypeUser, ID: 3}, order))
+	c.True(canReadServiceOrder(Actor{Type: shared.ClientTypeMechanic, ID: 2}, order))
+	c.False(canReadServiceOrder(Actor{Type: shared.ClientTypeMechanic, ID: 3}, order))
+	c.True(canReadServiceOrder(Actor{Type: shared.ClientTypeMechanic, ID: 3}, openOrder))
+	c.True(canReadServiceOrder(Actor{Type: shared.ClientTypeAdmin, ID: 9}, order))
+}
+
+func TestCanChangeServiceOrderStatus(t *testing.T) {
+	c := require.New(t)
+
+	order := ServiceOrder{UserID: 1, MechanicID: 2, Status: ServiceOrderStatusInProgress}
+
+	c.True(canChangeServiceOrderStatus(Actor{Type: shared.ClientTypeUser, ID: 1}, order, ServiceOrderStatusCancelled))
+	c.False(canChangeServiceOrderStatus(Actor{Type: shared.ClientTypeUser, ID: 1}, order, ServiceOrderStatusFinished))
+	c.False(canChangeServiceOrderStatus(Actor{Type: shared.ClientTypeUser, ID: 3}, order, ServiceOrderStatusCancelled))
+	c.True(canChangeServiceOrderStatus(Actor{Type: shared.ClientTypeMechanic, ID: 2}, order, ServiceOrderStatusFinished))
+	c.False(canChangeServiceOrderStatus(Actor{Type: shared.ClientTypeMechanic, ID: 3}, order, ServiceOrderStatusFinished))
+	c.True(canChangeServiceOrderStatus(Actor{Type: shared.ClientTypeAdmin, ID: 9}, order, ServiceOrderStatusFailure))
+}
+
+func TestChangeServiceOrderStatusCanNotStartAnOrder(t *testing.T) {
+	c := require.New(t)
+
+	for _, actorType := range []shared.ClientType{shared.ClientTypeUser, shared.ClientTypeMechanic, shared.ClientTypeAdmin} {
+		err := changeServiceOrderStatus(nil, nil, 1, ServiceOrderStatusInProgress, Actor{Type: actorType, ID: 1})
+		c.Equal(ErrStartRequiresMechanic, err)
+	}
+}
+
+func TestCanAssignMechanicToOrder(t *testing.T) {
+	c := require.New(t)
+
+	c.False(canAssignMechanicToOrder(Actor{Type: shared.ClientTypeMechanic, ID: 2}))
+	c.False(canAssignMechanicToOrder(Actor{Type: shared.ClientTypeUser, ID: 1}))
+	c.True(canAssignMechanicToOrder(Actor{Type: shared.ClientTypeAdmin, ID: 9}))
+}
+
+func TestAssignMechanicToOrderAuthorizesBeforeReadingTheOrder(t *testing.T) {
+	c := require.New(t)
+
+	// without a database reading the order would panic, so a forbidden error means it was never read
+	for _, actorType := range []shared.ClientType{shared.ClientTypeUser, shared.ClientTypeMechanic} {
+		err := assignMechanicToOrder(nil, nil, 2, 1, Actor{Type: actorType, ID: 1})
+		c.Equal(ErrForbidden, err)
+	}
+}
+
+func TestIsOfferOpen(t *testing.T) {
+	c := require.New(t)
+
+	now := time.Now()
+
+	c.True(IsOfferOpen(Offer{Status: OfferStatusPending, ExpiresAt: now.Add(time.Minute)}, now))
+	c.False(IsOfferOpen(Offer{Status: OfferStatusPending, ExpiresAt: now.Add(-time.Second)}, now))
+	c.False(IsOfferOpen(Offer{Status: OfferStatusDeclined, ExpiresAt: now.Add(time.Minute)}, now))
+	c.False(IsOfferOpen(Offer{Status: OfferStatusAccepted, ExpiresAt: now.Add(time.Minute)}, now))
+}
+
+func TestEventDispatcher(t *testing.T) {
+	c := require.New(t)
+
+	dispatcher := NewEventDispatcher()
+	handled := []string{}
+	dispatcher.On(OrderEventTypeStatusChanged, func(event OrderEvent, order ServiceOrder) error {
+		handled = append(handled, "first "+event.NewValue)
+		return errors.New("handler failed")
+	})
+	dispatcher.On(OrderEventTypeStatusChanged, func(event OrderEvent, order ServiceOrder) error {
+		handled = append(handled, "second "+event.NewValue)
+		return nil
+	})
+
+	dispatcher.Dispatch([]OrderEvent{
+		{EventType: OrderEventTypeMechanicAssigned, NewValue: "3"},
+		{EventType: OrderEventTypeStatusChanged, NewValue: string(ServiceOrderStatusInProgress)},
+	}, ServiceOrder{ServiceOrderID: 1})
+
+	c.Equal([]string{"first in_progress", "second in_progress"}, handled)
+
+	var nilDispatcher *EventDispatcher
+	nilDispatcher.Dispatch([]OrderEvent{{EventType: OrderEventTypeCancelled}}, ServiceOrder{})
+}
+
+func TestNotifyCancelledWithoutMechanic(t *testing.T) {
+	c := require.New(t)
+
+	notifier := &notifications.RecordingNotifier{}
+	event := OrderEvent{EventType: OrderEventTypeCancelled, ActorType: shared.ClientTypeUser, ActorID: 1}
+
+	c.NoError(notifyCancelled(nil, notifier, event, ServiceOrder{UserID: 1, Status: ServiceOrderStatusCancelled}))
+	c.Empty(notifier.Sent())
+}
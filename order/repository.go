@@ -2,11 +2,16 @@ package order
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"time"
 
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/outbox"
 	"github.com/CartechAPI/shared"
 	"github.com/lib/pq"
 )
@@ -16,20 +21,45 @@ var (
 	ErrNoRowsAffected = errors.New("no rows affected")
 )
 
+// insertServiceOrder creates the order and enqueues it for the assigner in the same transaction,
+// so an order is never left without being dispatched
 func insertServiceOrder(db *sql.DB, serviceOrder ServiceOrder) (int, error) {
 	query := `INSERT INTO service_order_table 
 				(service_id, user_id, created_at, status, lat, lng) 
 				VALUES ($1, $2, NOW(), $3, $4, $5) 
 				RETURNING service_order_id`
 
-	id := 0
-	err := db.QueryRow(query, serviceOrder.ServiceID, serviceOrder.UserID, serviceOrder.Status, serviceOrder.Lat, serviceOrder.Lng).Scan(&id)
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	err = tx.QueryRow(query, serviceOrder.ServiceID, serviceOrder.UserID, serviceOrder.Status, serviceOrder.Lat, serviceOrder.Lng).Scan(&serviceOrder.ServiceOrderID)
 	if err != nil {
 		log.Println("error inserting into service_order: " + err.Error())
 		return 0, err
 	}
 
-	return id, nil
+	marshalledOrder, err := json.Marshal(serviceOrder)
+	if err != nil {
+		log.Println("failed_to_marshall_order:" + err.Error())
+		return 0, err
+	}
+
+	err = outbox.Enqueue(tx, AssignerTopology.Exchange, AssignerTopology.RoutingKey(), marshalledOrder)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return serviceOrder.ServiceOrderID, nil
 }
 
 func getServiceOrderByUserIDAndStatus(db *sql.DB, userID int) ([]ServiceOrder, error) {
@@ -120,13 +150,23 @@ func selectAllOrdersFromMechanic(db *sql.DB, mechanicID int) ([]ServiceOrder, er
 	return scanServiceOrders(rows)
 }
 
-// TODO: paginate this
-func selectAllOrders(db *sql.DB) ([]ServiceOrder, error) {
+// selectOrdersPage returns a page of the orders of the platform, only the ones with the status if it is not empty
+func selectOrdersPage(db *sql.DB, status ServiceOrderStatus, limit int, offset int) ([]ServiceOrder, error) {
 	query := `SELECT service_order_id, service_order_table.service_id, user_id, mechanic_id, created_at, started_at, status, finished_at, cancelled_at, lat, lng, display_name
 	FROM service_order_table 
-	LEFT JOIN service_table ON service_order_table.service_id = service_table.service_id`
+	LEFT JOIN service_table ON service_order_table.service_id = service_table.service_id
+	%s
+	ORDER BY service_order_id
+	LIMIT $1 OFFSET $2`
+
+	args := []interface{}{limit, offset}
+	filter := ""
+	if status != "" {
+		filter = "WHERE status = $3"
+		args = append(args, status)
+	}
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(fmt.Sprintf(query, filter), args...)
 	if err != nil {
 		log.Println("error while selecting all service_order: " + err.Error())
 		return nil, err
@@ -227,53 +267,388 @@ func scanServiceOrders(rows *sql.Rows) ([]ServiceOrder, error) {
 	return serviceOrders, nil
 }
 
-func updateServiceOrderStatus(db *sql.DB, serviceOrderID int, status ServiceOrderStatus) error {
-	query := "UPDATE service_order_table SET status = $1 WHERE service_order_id = $2"
-	result, err := db.Exec(query, string(status), serviceOrderID)
+// updateServiceOrderStatus moves the order to the status and returns the events it recorded
+func updateServiceOrderStatus(db *sql.DB, serviceOrderID int, currentStatus ServiceOrderStatus, status ServiceOrderStatus, actor Actor) ([]OrderEvent, error) {
+	query := "UPDATE service_order_table SET status = $1 WHERE service_order_id = $2 AND status = $3 RETURNING mechanic_id"
+	if column, ok := serviceOrderStatusTimestamps[status]; ok {
+		query = fmt.Sprintf("UPDATE service_order_table SET status = $1, %s = NOW() WHERE service_order_id = $2 AND status = $3 RETURNING mechanic_id", column)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	var mechanicID sql.NullInt64
+	err = tx.QueryRow(query, string(status), serviceOrderID, string(currentStatus)).Scan(&mechanicID)
+	if err == sql.ErrNoRows {
+		log.Println("now rows affected")
+		return nil, ErrNoRowsAffected
+	}
+
 	if err != nil {
 		log.Println("error updating service order status: " + err.Error())
 		if pqErr, ok := err.(pq.Error); ok {
 			log.Println(pqErr.Error())
 		}
 
-		return err
+		return nil, err
 	}
 
-	rowsAffectes, err := result.RowsAffected()
+	if isServiceOrderStatusFinal(status) && mechanicID.Valid {
+		err = mec.ReleaseBusyMechanic(tx, int(mechanicID.Int64))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	eventType := OrderEventTypeStatusChanged
+	if status == ServiceOrderStatusCancelled {
+		eventType = OrderEventTypeCancelled
+	}
+
+	event := OrderEvent{
+		ServiceOrderID: serviceOrderID,
+		EventType:      eventType,
+		ActorType:      actor.Type,
+		ActorID:        actor.ID,
+		OldValue:       string(currentStatus),
+		NewValue:       string(status),
+	}
+
+	err = insertOrderEvent(tx, event)
 	if err != nil {
-		log.Println(err)
-		return err
+		return nil, err
 	}
 
-	if rowsAffectes == 0 {
-		log.Println("now rows affected")
-		return ErrNoRowsAffected
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return []OrderEvent{event}, nil
 }
 
-func setOrderMechanic(db *sql.DB, orderID int, mechanicID int) error {
+func setOrderMechanic(db *sql.DB, orderID int, mechanicID int, actor Actor) ([]OrderEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	events, err := assignOrderMechanic(tx, orderID, mechanicID, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// assignOrderMechanic assigns the mechanic to the order as part of the transaction, only an order that is
+// still pending and without a mechanic can be assigned so two mechanics can not take the same order.
+// It returns the events it recorded
+func assignOrderMechanic(tx *sql.Tx, orderID int, mechanicID int, actor Actor) ([]OrderEvent, error) {
 	query := `UPDATE service_order_table
-			SET mechanic_id = $1, status = $2
-			WHERE service_order_id = $3`
+			SET mechanic_id = $1, status = $2, started_at = NOW()
+			WHERE service_order_id = $3 AND status = $4 AND mechanic_id IS NULL`
 
-	result, err := db.Exec(query, mechanicID, ServiceOrderStatusInProgress, orderID)
+	result, err := tx.Exec(query, mechanicID, ServiceOrderStatusInProgress, orderID, ServiceOrderStatusPending)
 	if err != nil {
 		log.Println("assigning_mechanic_to_order_failed: " + err.Error())
-		return err
+		return nil, err
 	}
 
 	rowsAffectes, err := result.RowsAffected()
 	if err != nil {
 		log.Println(err)
-		return err
+		return nil, err
 	}
 
 	if rowsAffectes == 0 {
 		log.Println("now rows affected")
-		return ErrNoRowsAffected
+		return nil, ErrNoRowsAffected
+	}
+
+	err = mec.MarkMechanicBusy(tx, mechanicID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []OrderEvent{
+		{
+			ServiceOrderID: orderID,
+			EventType:      OrderEventTypeMechanicAssigned,
+			ActorType:      actor.Type,
+			ActorID:        actor.ID,
+			NewValue:       strconv.Itoa(mechanicID),
+		},
+		{
+			ServiceOrderID: orderID,
+			EventType:      OrderEventTypeStatusChanged,
+			ActorType:      actor.Type,
+			ActorID:        actor.ID,
+			OldValue:       string(ServiceOrderStatusPending),
+			NewValue:       string(ServiceOrderStatusInProgress),
+		},
+	}
+
+	for _, event := range events {
+		err = insertOrderEvent(tx, event)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return events, nil
+}
+
+func insertOrderEvent(tx *sql.Tx, event OrderEvent) error {
+	query := `INSERT INTO order_event_table
+			(service_order_id, event_type, actor_type, actor_id, old_value, new_value, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, NOW())`
+
+	_, err := tx.Exec(query, event.ServiceOrderID, event.EventType, event.ActorType, event.ActorID, event.OldValue, event.NewValue)
+	if err != nil {
+		log.Println("error inserting into order_event_table: " + err.Error())
+		return err
 	}
 
 	return nil
 }
+
+func selectOrderEvents(db *sql.DB, serviceOrderID int) ([]OrderEvent, error) {
+	query := `SELECT order_event_id, service_order_id, event_type, actor_type, actor_id, old_value, new_value, created_at
+	FROM order_event_table
+	WHERE service_order_id = $1
+	ORDER BY created_at, order_event_id`
+
+	rows, err := db.Query(query, serviceOrderID)
+	if err != nil {
+		log.Println("error while selecting from order_event_table: " + err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []OrderEvent{}
+	for rows.Next() {
+		event := OrderEvent{}
+		err := rows.Scan(&event.OrderEventID, &event.ServiceOrderID, &event.EventType, &event.ActorType, &event.ActorID, &event.OldValue, &event.NewValue, &event.CreatedAt)
+		if err != nil {
+			log.Println("error while scanning order_events: " + err.Error())
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+const offerColumns = "offer_id, service_order_id, mechanic_id, status, distance_km, created_at, expires_at, responded_at"
+
+func scanOffer(row scanner) (*Offer, error) {
+	offer := Offer{}
+	var respondedAt sql.NullTime
+	err := row.Scan(&offer.OfferID, &offer.ServiceOrderID, &offer.MechanicID, &offer.Status, &offer.DistanceKm, &offer.CreatedAt, &offer.ExpiresAt, &respondedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if respondedAt.Valid {
+		offer.RespondedAt = &respondedAt.Time
+	}
+
+	return &offer, nil
+}
+
+func scanOffers(rows *sql.Rows) ([]Offer, error) {
+	defer rows.Close()
+
+	offers := []Offer{}
+	for rows.Next() {
+		offer, err := scanOffer(rows)
+		if err != nil {
+			log.Println("error scanning offer: " + err.Error())
+			return nil, err
+		}
+
+		offers = append(offers, *offer)
+	}
+
+	return offers, rows.Err()
+}
+
+func insertOffer(db *sql.DB, offer Offer) (*Offer, error) {
+	query := `INSERT INTO offer_table (service_order_id, mechanic_id, status, distance_km, created_at, expires_at)
+	VALUES ($1, $2, $3, $4, NOW(), $5)
+	RETURNING ` + offerColumns
+
+	created, err := scanOffer(db.QueryRow(query, offer.ServiceOrderID, offer.MechanicID, OfferStatusPending, offer.DistanceKm, offer.ExpiresAt))
+	if err != nil {
+		log.Println("error inserting into offer_table: " + err.Error())
+		return nil, err
+	}
+
+	return created, nil
+}
+
+func selectOfferByID(db *sql.DB, offerID int) (*Offer, error) {
+	query := "SELECT " + offerColumns + " FROM offer_table WHERE offer_id = $1"
+
+	offer, err := scanOffer(db.QueryRow(query, offerID))
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("error selecting from offer_table: " + err.Error())
+	}
+
+	return offer, err
+}
+
+func selectOrderOffers(db *sql.DB, serviceOrderID int) ([]Offer, error) {
+	query := "SELECT " + offerColumns + " FROM offer_table WHERE service_order_id = $1 ORDER BY offer_id"
+
+	rows, err := db.Query(query, serviceOrderID)
+	if err != nil {
+		log.Println("error selecting from offer_table: " + err.Error())
+		return nil, err
+	}
+
+	return scanOffers(rows)
+}
+
+func selectMechanicOpenOffers(db *sql.DB, mechanicID int) ([]Offer, error) {
+	query := "SELECT " + offerColumns + " FROM offer_table WHERE mechanic_id = $1 AND status = $2 AND expires_at > NOW() ORDER BY offer_id"
+
+	rows, err := db.Query(query, mechanicID, OfferStatusPending)
+	if err != nil {
+		log.Println("error selecting from offer_table: " + err.Error())
+		return nil, err
+	}
+
+	return scanOffers(rows)
+}
+
+// acceptOfferAndAssign assigns the mechanic of the offer to its order and closes every other offer of the order.
+// The order row is locked first, so a mechanic that loses the race never holds a lock the winner needs
+func acceptOfferAndAssign(db *sql.DB, offer Offer, actor Actor) ([]OrderEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	events, err := assignOrderMechanic(tx, offer.ServiceOrderID, offer.MechanicID, actor)
+	if err == ErrNoRowsAffected {
+		return nil, ErrOrderAlreadyTaken
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE offer_table SET status = $1, responded_at = NOW() WHERE offer_id = $2 AND status = $3 AND expires_at > NOW()"
+	result, err := tx.Exec(query, OfferStatusAccepted, offer.OfferID, OfferStatusPending)
+	if err != nil {
+		log.Println("error updating offer_table: " + err.Error())
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrOfferNotAvailable
+	}
+
+	query = "UPDATE offer_table SET status = $1 WHERE service_order_id = $2 AND status = $3"
+	_, err = tx.Exec(query, OfferStatusExpired, offer.ServiceOrderID, OfferStatusPending)
+	if err != nil {
+		log.Println("error updating offer_table: " + err.Error())
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func updateOfferDeclined(db *sql.DB, offerID int) (bool, error) {
+	query := "UPDATE offer_table SET status = $1, responded_at = NOW() WHERE offer_id = $2 AND status = $3 AND expires_at > NOW()"
+
+	result, err := db.Exec(query, OfferStatusDeclined, offerID, OfferStatusPending)
+	if err != nil {
+		log.Println("error updating offer_table: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func updateExpiredOffers(db *sql.DB) (int64, error) {
+	query := "UPDATE offer_table SET status = $1 WHERE status = $2 AND expires_at <= NOW()"
+
+	result, err := db.Exec(query, OfferStatusExpired, OfferStatusPending)
+	if err != nil {
+		log.Println("error updating offer_table: " + err.Error())
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// selectOrdersAwaitingMechanic returns the ids of the pending orders created after the given time that have
+// less open offers than wanted
+func selectOrdersAwaitingMechanic(db *sql.DB, openOffers int, createdAfter time.Time) ([]int, error) {
+	query := `SELECT service_order_id FROM service_order_table
+	WHERE status = $1 AND mechanic_id IS NULL AND created_at > $2
+	AND (SELECT COUNT(*) FROM offer_table
+		WHERE offer_table.service_order_id = service_order_table.service_order_id AND offer_table.status = $3 AND offer_table.expires_at > NOW()) < $4
+	ORDER BY created_at`
+
+	rows, err := db.Query(query, ServiceOrderStatusPending, createdAfter, OfferStatusPending, openOffers)
+	if err != nil {
+		log.Println("error selecting orders awaiting mechanic: " + err.Error())
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	orderIDs := []int{}
+	for rows.Next() {
+		var orderID int
+		err := rows.Scan(&orderID)
+		if err != nil {
+			return nil, err
+		}
+
+		orderIDs = append(orderIDs, orderID)
+	}
+
+	return orderIDs, rows.Err()
+}
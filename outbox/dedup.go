@@ -0,0 +1,50 @@
+package outbox
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// processedRetention is how long a processed message is remembered. It is as long as the published messages are
+// kept, far longer than the retries of a message and the redeliveries of the broker
+const processedRetention = publishedRetention
+
+// IsProcessed tells if the consumer already handled the message
+func IsProcessed(db *sql.DB, consumer string, messageID string) (bool, error) {
+	query := "SELECT EXISTS (SELECT 1 FROM processed_message_table WHERE consumer = $1 AND message_id = $2)"
+
+	var processed bool
+	err := db.QueryRow(query, consumer, messageID).Scan(&processed)
+	if err != nil {
+		log.Println("error selecting from processed_message_table: " + err.Error())
+		return false, err
+	}
+
+	return processed, nil
+}
+
+// MarkProcessed records that the consumer handled the message, so redeliveries of it are ignored
+func MarkProcessed(db *sql.DB, consumer string, messageID string) error {
+	query := `INSERT INTO processed_message_table (consumer, message_id, processed_at) VALUES ($1, $2, NOW())
+	ON CONFLICT (consumer, message_id) DO NOTHING`
+
+	_, err := db.Exec(query, consumer, messageID)
+	if err != nil {
+		log.Println("error inserting into processed_message_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// deleteExpiredProcessed forgets the messages processed before the retention, they can no longer be redelivered
+func deleteExpiredProcessed(tx *sql.Tx, now time.Time) error {
+	_, err := tx.Exec("DELETE FROM processed_message_table WHERE processed_at < $1", now.Add(-processedRetention))
+	if err != nil {
+		log.Println("error deleting from processed_message_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
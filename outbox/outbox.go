@@ -0,0 +1,148 @@
+package outbox
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"github.com/CartechAPI/queue"
+)
+
+const (
+	// relayBatchSize is how many messages the relay publishes on every run
+	relayBatchSize = 100
+	// publishedRetention is how long the published messages are kept
+	publishedRetention = 7 * 24 * time.Hour
+)
+
+// Message is a message waiting to be published
+type Message struct {
+	OutboxID   int
+	MessageID  string
+	Exchange   string
+	RoutingKey string
+	Payload    []byte
+}
+
+func newMessageID() (string, error) {
+	idBytes := make([]byte, 16)
+	_, err := rand.Read(idBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(idBytes), nil
+}
+
+// Enqueue stores the message as part of the transaction, so it is published if and only if the transaction commits
+func Enqueue(tx *sql.Tx, exchange string, routingKey string, payload []byte) error {
+	messageID, err := newMessageID()
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO outbox_table (message_id, exchange, routing_key, payload, created_at)
+	VALUES ($1, $2, $3, $4, NOW())`
+
+	_, err = tx.Exec(query, messageID, exchange, routingKey, payload)
+	if err != nil {
+		log.Println("error inserting into outbox_table: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// RunRelay publishes the pending messages every interval until stop is closed. A message is only marked as
+// published once the publisher returns, so every message is delivered at least once and the consumers have
+// to deduplicate them by message id
+func RunRelay(db *sql.DB, publisher queue.Publisher, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := relay(db, publisher)
+			if err != nil {
+				log.Println("error_relaying_outbox: " + err.Error())
+			}
+		}
+	}
+}
+
+// relay publishes a batch of pending messages in order, it stops at the first failure so the
+// remaining ones are retried on the next run. It also deletes the published and processed messages past their retention
+func relay(db *sql.DB, publisher queue.Publisher) error {
+	tx, err := db.Begin()
+	if err != nil {
+		log.Println("error beginning transaction: " + err.Error())
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// skip locked lets several relays run at the same time without publishing the same rows
+	query := `SELECT outbox_id, message_id, exchange, routing_key, payload FROM outbox_table
+	WHERE published_at IS NULL
+	ORDER BY outbox_id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED`
+
+	rows, err := tx.Query(query, relayBatchSize)
+	if err != nil {
+		log.Println("error selecting from outbox_table: " + err.Error())
+		return err
+	}
+
+	messages := []Message{}
+	for rows.Next() {
+		message := Message{}
+		err = rows.Scan(&message.OutboxID, &message.MessageID, &message.Exchange, &message.RoutingKey, &message.Payload)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		messages = append(messages, message)
+	}
+
+	rows.Close()
+
+	var publishErr error
+	for _, message := range messages {
+		publishErr = publisher.Publish(message.Exchange, message.RoutingKey, queue.Message{ID: message.MessageID, Body: message.Payload})
+		if publishErr != nil {
+			break
+		}
+
+		_, err = tx.Exec("UPDATE outbox_table SET published_at = NOW() WHERE outbox_id = $1", message.OutboxID)
+		if err != nil {
+			log.Println("error updating outbox_table: " + err.Error())
+			return err
+		}
+	}
+
+	now := time.Now()
+	_, err = tx.Exec("DELETE FROM outbox_table WHERE published_at < $1", now.Add(-publishedRetention))
+	if err != nil {
+		log.Println("error deleting from outbox_table: " + err.Error())
+		return err
+	}
+
+	err = deleteExpiredProcessed(tx, now)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	return publishErr
+}
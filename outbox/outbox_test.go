@@ -0,0 +1,19 @@
+package outbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMessageID(t *testing.T) {
+	c := require.New(t)
+
+	first, err := newMessageID()
+	c.Nil(err)
+	c.Len(first, 32)
+
+	second, err := newMessageID()
+	c.Nil(err)
+	c.NotEqual(first, second)
+}
@@ -0,0 +1,193 @@
+package profile
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CartechAPI/auth"
+	"github.com/CartechAPI/shared"
+	"github.com/CartechAPI/utils"
+)
+
+// GetProfile handles the request for getting the profile of the client
+func GetProfile(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		profile, err := getProfile(db, clientType, id)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(w, http.StatusNotFound, "resource not found")
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, profile)
+	}
+}
+
+// UpdateProfile handles the request for updating the profile of the client
+func UpdateProfile(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		patchRequest := shared.PatchRequestBody{}
+		err = json.NewDecoder(r.Body).Decode(&patchRequest)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		profile, err := updateProfile(db, clientType, id, patchRequest)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err == sql.ErrNoRows {
+			utils.RespondWithError(w, http.StatusNotFound, "resource not found")
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, profile)
+	}
+}
+
+// ChangePassword handles the request for changing the password of the client
+func ChangePassword(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		body := struct {
+			CurrentPassword string `json:"current_password"`
+			Password        string `json:"password"`
+		}{}
+
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		if clientType != shared.ClientTypeUser && clientType != shared.ClientTypeMechanic {
+			utils.RespondWithError(w, ErrUnsupportedClient.StatusCode, ErrUnsupportedClient.Message)
+			return
+		}
+
+		err = auth.ChangePassword(db, clientType, id, body.CurrentPassword, body.Password)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// DeleteAccount handles the request for deleting the account of the client
+func DeleteAccount(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		err = requestDeletion(db, clientType, id)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusAccepted, map[string]interface{}{
+			"message":          "account scheduled for deletion",
+			"restorable_until": time.Now().Add(DeletionGracePeriod),
+		})
+	}
+}
+
+// RestoreAccount handles the request for cancelling the deletion of the account of the client
+func RestoreAccount(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		err = cancelDeletion(db, clientType, id)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ExportData handles the request for downloading the personal data of the client
+func ExportData(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		export, err := exportData(db, clientType, id)
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		w.Header().Set("Content-Disposition", `attachment; filename="cartech-export.json"`)
+		utils.RespondJSON(w, http.StatusOK, export)
+	}
+}
@@ -0,0 +1,55 @@
+package profile
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CartechAPI/auth"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/shared"
+	"github.com/CartechAPI/utils"
+)
+
+// ErrInvalidAvailability the availability is not one a mechanic can report
+var ErrInvalidAvailability = shared.NewBadRequestError("invalid availability")
+
+// UpdateAvailability handles the request of a mechanic for reporting if it can take orders, the
+// apps call it periodically as a heartbeat. The response has the stored status, which stays busy during an order
+func UpdateAvailability(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		if clientType != shared.ClientTypeMechanic {
+			utils.RespondWithError(w, ErrUnsupportedClient.StatusCode, ErrUnsupportedClient.Message)
+			return
+		}
+
+		body := struct {
+			Status mec.Availability `json:"status"`
+		}{}
+
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		if !mec.IsAvailabilitySettable(body.Status) {
+			utils.RespondWithError(w, ErrInvalidAvailability.StatusCode, ErrInvalidAvailability.Message)
+			return
+		}
+
+		status, err := mec.SetMechanicAvailability(db, id, body.Status)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, status)
+	}
+}
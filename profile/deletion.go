@@ -0,0 +1,176 @@
+package profile
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/CartechAPI/auth"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/notifications"
+	"github.com/CartechAPI/order"
+	"github.com/CartechAPI/shared"
+	us "github.com/CartechAPI/user"
+)
+
+var (
+	// ErrNoPendingDeletion there is no deletion to cancel
+	ErrNoPendingDeletion = shared.NewShowableError("account has no pending deletion", http.StatusConflict)
+)
+
+// DeletionGracePeriod is how long an account can be restored after requesting its deletion
+const DeletionGracePeriod = 30 * 24 * time.Hour
+
+// Export is the personal data of a client
+type Export struct {
+	ExportedAt time.Time            `json:"exported_at"`
+	ClientType shared.ClientType    `json:"client_type"`
+	Profile    interface{}          `json:"profile"`
+	Sessions   []auth.Session       `json:"sessions"`
+	Orders     []order.ServiceOrder `json:"orders"`
+}
+
+// requestDeletion schedules the anonymization of the account and ends all of its sessions
+func requestDeletion(db *sql.DB, clientType shared.ClientType, id int) error {
+	var err error
+	switch clientType {
+	case shared.ClientTypeUser:
+		err = us.RequestUserDeletion(db, id)
+	case shared.ClientTypeMechanic:
+		err = mec.RequestMechanicDeletion(db, id)
+	default:
+		return ErrUnsupportedClient
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return auth.RevokeAllSessions(db, clientType, id)
+}
+
+func cancelDeletion(db *sql.DB, clientType shared.ClientType, id int) error {
+	var cancelled bool
+	var err error
+	switch clientType {
+	case shared.ClientTypeUser:
+		cancelled, err = us.CancelUserDeletion(db, id)
+	case shared.ClientTypeMechanic:
+		cancelled, err = mec.CancelMechanicDeletion(db, id)
+	default:
+		return ErrUnsupportedClient
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !cancelled {
+		return ErrNoPendingDeletion
+	}
+
+	return nil
+}
+
+func exportData(db *sql.DB, clientType shared.ClientType, id int) (*Export, error) {
+	profile, err := getProfile(db, clientType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := auth.GetClientSessions(db, clientType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := order.GetClientServiceOrders(db, clientType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Export{
+		ExportedAt: time.Now(),
+		ClientType: clientType,
+		Profile:    profile,
+		Sessions:   sessions,
+		Orders:     orders,
+	}, nil
+}
+
+// AnonymizeDeletedAccounts anonymizes the accounts whose grace period is over and deletes the rest of their
+// personal data in the same transaction
+func AnonymizeDeletedAccounts(db *sql.DB) error {
+	requestedBefore := time.Now().Add(-DeletionGracePeriod)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	users, err := us.AnonymizeDeletedUsers(tx, requestedBefore)
+	if err != nil {
+		return err
+	}
+
+	err = deleteAnonymizedClientsData(tx, shared.ClientTypeUser, users)
+	if err != nil {
+		return err
+	}
+
+	mechanics, err := mec.AnonymizeDeletedMechanics(tx, requestedBefore)
+	if err != nil {
+		return err
+	}
+
+	err = deleteAnonymizedClientsData(tx, shared.ClientTypeMechanic, mechanics)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	if len(users) > 0 || len(mechanics) > 0 {
+		log.Printf("anonymized %d users and %d mechanics\n", len(users), len(mechanics))
+	}
+
+	return nil
+}
+
+func deleteAnonymizedClientsData(tx *sql.Tx, clientType shared.ClientType, clients []shared.AnonymizedClient) error {
+	if len(clients) == 0 {
+		return nil
+	}
+
+	err := auth.DeleteAnonymizedClientsData(tx, clientType, clients)
+	if err != nil {
+		return err
+	}
+
+	clientIDs := []int64{}
+	for _, client := range clients {
+		clientIDs = append(clientIDs, int64(client.ClientID))
+	}
+
+	return notifications.DeleteClientsNotifications(tx, clientType, clientIDs)
+}
+
+// RunDeletionJob anonymizes the deleted accounts every interval, it never returns
+func RunDeletionJob(db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := AnonymizeDeletedAccounts(db)
+		if err != nil {
+			log.Println("error_anonymizing_deleted_accounts: " + err.Error())
+		}
+
+		<-ticker.C
+	}
+}
@@ -0,0 +1,69 @@
+package profile
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CartechAPI/auth"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/shared"
+	"github.com/CartechAPI/utils"
+)
+
+// ErrInvalidLocation the coordinates are out of range
+var ErrInvalidLocation = shared.NewBadRequestError("invalid location")
+
+type locationRequest struct {
+	Lat *float64 `json:"lat"`
+	Lng *float64 `json:"lng"`
+}
+
+func validateLocation(location locationRequest) error {
+	if location.Lat == nil || location.Lng == nil {
+		return ErrInvalidLocation
+	}
+
+	if *location.Lat < -90 || *location.Lat > 90 || *location.Lng < -180 || *location.Lng > 180 {
+		return ErrInvalidLocation
+	}
+
+	return nil
+}
+
+// UpdateLocation handles the request of a mechanic for reporting its current position
+func UpdateLocation(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		if clientType != shared.ClientTypeMechanic {
+			utils.RespondWithError(w, ErrUnsupportedClient.StatusCode, ErrUnsupportedClient.Message)
+			return
+		}
+
+		body := locationRequest{}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err = validateLocation(body)
+		if err != nil {
+			utils.RespondWithError(w, ErrInvalidLocation.StatusCode, ErrInvalidLocation.Message)
+			return
+		}
+
+		location, err := mec.UpdateMechanicLocation(db, id, *body.Lat, *body.Lng)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, location)
+	}
+}
@@ -0,0 +1,164 @@
+package profile
+
+import (
+	"database/sql"
+	"net/http"
+
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/shared"
+	us "github.com/CartechAPI/user"
+)
+
+var (
+	// ErrUnsupportedClient the client type has no profile
+	ErrUnsupportedClient = shared.NewShowableError("client type has no profile", http.StatusForbidden)
+	// ErrInvalidPath the field can not be updated
+	ErrInvalidPath = shared.NewBadRequestError("invalid patch path")
+	// ErrInvalidOp the operation is not supported
+	ErrInvalidOp = shared.NewBadRequestError("invalid patch operation")
+	// ErrEmptyValue the field can not be empty
+	ErrEmptyValue = shared.NewBadRequestError("value can not be empty")
+	// ErrUnsupportedLocale the messages are not available in the locale
+	ErrUnsupportedLocale = shared.NewBadRequestError("unsupported locale")
+)
+
+// getProfile returns the user or mechanic without its password
+func getProfile(db *sql.DB, clientType shared.ClientType, id int) (interface{}, error) {
+	switch clientType {
+	case shared.ClientTypeUser:
+		user, err := us.GetUserByID(db, id)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Password = ""
+		return user, nil
+	case shared.ClientTypeMechanic:
+		mechanic, err := mec.GetMechanicByID(db, id)
+		if err != nil {
+			return nil, err
+		}
+
+		mechanic.Password = ""
+		return mechanic, nil
+	}
+
+	return nil, ErrUnsupportedClient
+}
+
+func validatePatchRequest(patchRequest shared.PatchRequestBody, allowedPaths map[string]bool) error {
+	for _, request := range patchRequest {
+		if request.Op != shared.PatchOpReplace {
+			return ErrInvalidOp
+		}
+
+		if !allowedPaths[request.Path] {
+			return ErrInvalidPath
+		}
+
+		// only the bio can be cleared
+		if request.Value == "" && request.Path != "bio" {
+			return ErrEmptyValue
+		}
+
+		if request.Path == "locale" && !shared.IsLocaleSupported(shared.Locale(request.Value)) {
+			return ErrUnsupportedLocale
+		}
+	}
+
+	return nil
+}
+
+var userPaths = map[string]bool{"name": true, "last_name": true, "phone_number": true, "locale": true}
+
+var mechanicPaths = map[string]bool{"name": true, "last_name": true, "phone_number": true, "bio": true, "locale": true}
+
+func updateUserProfile(db *sql.DB, id int, patchRequest shared.PatchRequestBody) (*us.User, error) {
+	err := validatePatchRequest(patchRequest, userPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := us.GetUserByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, request := range patchRequest {
+		switch request.Path {
+		case "name":
+			user.Name = request.Value
+		case "last_name":
+			user.LastName = request.Value
+		case "phone_number":
+			user.PhoneNumber = request.Value
+		case "locale":
+			user.Locale = request.Value
+		}
+	}
+
+	err = us.UpdateUser(db, *user)
+	if err != nil {
+		return nil, err
+	}
+
+	return us.GetUserByID(db, id)
+}
+
+func updateMechanicProfile(db *sql.DB, id int, patchRequest shared.PatchRequestBody) (*mec.Mechanic, error) {
+	err := validatePatchRequest(patchRequest, mechanicPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	mechanic, err := mec.GetMechanicByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, request := range patchRequest {
+		switch request.Path {
+		case "name":
+			mechanic.Name = request.Value
+		case "last_name":
+			mechanic.LastName = request.Value
+		case "phone_number":
+			mechanic.PhoneNumber = request.Value
+		case "bio":
+			mechanic.Bio = request.Value
+		case "locale":
+			mechanic.Locale = request.Value
+		}
+	}
+
+	err = mec.UpdateMechanic(db, *mechanic)
+	if err != nil {
+		return nil, err
+	}
+
+	return mec.GetMechanicByID(db, id)
+}
+
+// updateProfile applies the replace operations to the profile of the client
+func updateProfile(db *sql.DB, clientType shared.ClientType, id int, patchRequest shared.PatchRequestBody) (interface{}, error) {
+	switch clientType {
+	case shared.ClientTypeUser:
+		user, err := updateUserProfile(db, id, patchRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		user.Password = ""
+		return user, nil
+	case shared.ClientTypeMechanic:
+		mechanic, err := updateMechanicProfile(db, id, patchRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		mechanic.Password = ""
+		return mechanic, nil
+	}
+
+	return nil, ErrUnsupportedClient
+}
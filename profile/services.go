@@ -0,0 +1,99 @@
+package profile
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CartechAPI/auth"
+	mec "github.com/CartechAPI/mechanic"
+	"github.com/CartechAPI/shared"
+	"github.com/CartechAPI/utils"
+)
+
+// ErrInvalidServiceID the service id is not valid
+var ErrInvalidServiceID = shared.NewBadRequestError("invalid service id")
+
+type servicesBody struct {
+	ServiceIDs []int `json:"service_ids"`
+}
+
+func validateServiceIDs(serviceIDs []int) error {
+	for _, serviceID := range serviceIDs {
+		if serviceID <= 0 {
+			return ErrInvalidServiceID
+		}
+	}
+
+	return nil
+}
+
+// GetServices handles the request of a mechanic for listing the services it offers
+func GetServices(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		if clientType != shared.ClientTypeMechanic {
+			utils.RespondWithError(w, ErrUnsupportedClient.StatusCode, ErrUnsupportedClient.Message)
+			return
+		}
+
+		serviceIDs, err := mec.GetMechanicServiceIDs(db, id)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, servicesBody{ServiceIDs: serviceIDs})
+	}
+}
+
+// UpdateServices handles the request of a mechanic for replacing the services it offers
+func UpdateServices(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientType, id, err := auth.UserAuthenticationMiddleware(db, r)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "client is unauthorized to perform the request")
+			return
+		}
+
+		if clientType != shared.ClientTypeMechanic {
+			utils.RespondWithError(w, ErrUnsupportedClient.StatusCode, ErrUnsupportedClient.Message)
+			return
+		}
+
+		body := servicesBody{}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err = validateServiceIDs(body.ServiceIDs)
+		if err == nil {
+			err = mec.SetMechanicServices(db, id, body.ServiceIDs)
+		}
+
+		if showableErr, ok := err.(shared.ShowableError); ok {
+			utils.RespondWithError(w, showableErr.StatusCode, showableErr.Message)
+			return
+		}
+
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		serviceIDs, err := mec.GetMechanicServiceIDs(db, id)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		utils.RespondJSON(w, http.StatusOK, servicesBody{ServiceIDs: serviceIDs})
+	}
+}
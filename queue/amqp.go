@@ -0,0 +1,120 @@
+package queue
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrPublishNotConfirmed the broker did not confirm the message
+var ErrPublishNotConfirmed = errors.New("publish was not confirmed by the broker")
+
+// AMQPPublisher publishes to a RabbitMQ channel in confirm mode
+type AMQPPublisher struct {
+	mu       sync.Mutex
+	channel  *amqp.Channel
+	confirms chan amqp.Confirmation
+}
+
+// NewAMQPPublisher puts the channel in confirm mode, the channel should not be used for anything else
+func NewAMQPPublisher(channel *amqp.Channel) (*AMQPPublisher, error) {
+	err := channel.Confirm(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AMQPPublisher{
+		channel:  channel,
+		confirms: channel.NotifyPublish(make(chan amqp.Confirmation, 1)),
+	}, nil
+}
+
+// Publish publishes a persistent message and waits for the broker to confirm it
+func (publisher *AMQPPublisher) Publish(exchange string, routingKey string, message Message) error {
+	return publisher.publish(exchange, routingKey, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    message.ID,
+		Body:         message.Body,
+	})
+}
+
+// publish publishes the message and waits for the broker to confirm it
+func (publisher *AMQPPublisher) publish(exchange string, routingKey string, publishing amqp.Publishing) error {
+	publisher.mu.Lock()
+	defer publisher.mu.Unlock()
+
+	err := publisher.channel.Publish(exchange, routingKey, false, false, publishing)
+	if err != nil {
+		return err
+	}
+
+	confirmation, ok := <-publisher.confirms
+	if !ok || !confirmation.Ack {
+		return ErrPublishNotConfirmed
+	}
+
+	return nil
+}
+
+// AMQPConsumer consumes the work queue of a topology with manual acknowledgements, failed
+// messages go through the retry queues of the topology
+type AMQPConsumer struct {
+	channel   *amqp.Channel
+	publisher *AMQPPublisher
+	topology  Topology
+	tag       string
+}
+
+// NewAMQPConsumer returns a consumer of the work queue of the topology, the topology must be declared already.
+// Failed messages are moved with the publisher, which must be on another channel than the consumer
+func NewAMQPConsumer(channel *amqp.Channel, publisher *AMQPPublisher, topology Topology, tag string) *AMQPConsumer {
+	return &AMQPConsumer{
+		channel:   channel,
+		publisher: publisher,
+		topology:  topology,
+		tag:       tag,
+	}
+}
+
+// Consume handles the messages until the consumer is stopped
+func (consumer *AMQPConsumer) Consume(handler Handler) error {
+	// one message at a time, so a crash only leaves one unacknowledged message behind
+	err := consumer.channel.Qos(1, 0, false)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := consumer.channel.Consume(consumer.topology.Queue, consumer.tag, false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for delivery := range deliveries {
+		handlerErr := handler(Message{ID: delivery.MessageId, Body: delivery.Body})
+		if handlerErr == nil {
+			err = delivery.Ack(false)
+			if err != nil {
+				log.Println("error_acknowledging_message: " + err.Error())
+			}
+
+			continue
+		}
+
+		log.Println("error_handling_message: " + handlerErr.Error())
+
+		err = consumer.topology.Retry(consumer.publisher, delivery, handlerErr)
+		if err != nil {
+			log.Println("error_retrying_message: " + err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Stop cancels the deliveries
+func (consumer *AMQPConsumer) Stop() error {
+	return consumer.channel.Cancel(consumer.tag, false)
+}
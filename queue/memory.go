@@ -0,0 +1,126 @@
+package queue
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// memoryQueueSize is how many messages an in memory queue holds before Publish blocks
+const memoryQueueSize = 1024
+
+type memoryDelivery struct {
+	message    Message
+	retryCount int
+}
+
+// MemoryBroker is an in process broker for local runs and tests. Exchanges are ignored and every routing key
+// is a queue of the same name, which is how the topologies bind their work queues
+type MemoryBroker struct {
+	mu          sync.Mutex
+	queues      map[string]chan memoryDelivery
+	deadLetters map[string][]Message
+}
+
+// NewMemoryBroker returns an empty broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		queues:      map[string]chan memoryDelivery{},
+		deadLetters: map[string][]Message{},
+	}
+}
+
+func (broker *MemoryBroker) queue(name string) chan memoryDelivery {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	queue, ok := broker.queues[name]
+	if !ok {
+		queue = make(chan memoryDelivery, memoryQueueSize)
+		broker.queues[name] = queue
+	}
+
+	return queue
+}
+
+// Publish puts the message in the queue of the routing key
+func (broker *MemoryBroker) Publish(exchange string, routingKey string, message Message) error {
+	broker.queue(routingKey) <- memoryDelivery{message: message}
+	return nil
+}
+
+// DeadLetters returns the messages of the queue that ran out of retries
+func (broker *MemoryBroker) DeadLetters(queue string) []Message {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	return append([]Message{}, broker.deadLetters[queue]...)
+}
+
+func (broker *MemoryBroker) addDeadLetter(queue string, message Message) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	broker.deadLetters[queue] = append(broker.deadLetters[queue], message)
+}
+
+// NewConsumer returns a consumer of the work queue of the topology that retries with the delays of the topology
+func (broker *MemoryBroker) NewConsumer(topology Topology) *MemoryConsumer {
+	return &MemoryConsumer{
+		broker:   broker,
+		topology: topology,
+		stop:     make(chan struct{}),
+	}
+}
+
+// MemoryConsumer consumes a queue of a MemoryBroker
+type MemoryConsumer struct {
+	broker   *MemoryBroker
+	topology Topology
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Consume handles the messages until the consumer is stopped
+func (consumer *MemoryConsumer) Consume(handler Handler) error {
+	deliveries := consumer.broker.queue(consumer.topology.Queue)
+
+	for {
+		select {
+		case <-consumer.stop:
+			return nil
+		case delivery := <-deliveries:
+			consumer.handle(handler, delivery)
+		}
+	}
+}
+
+func (consumer *MemoryConsumer) handle(handler Handler, delivery memoryDelivery) {
+	err := handler(delivery.message)
+	if err == nil {
+		return
+	}
+
+	log.Println("error_handling_message: " + err.Error())
+
+	if consumer.topology.nextQueue(delivery.retryCount, err) == consumer.topology.DeadLetterQueue() {
+		consumer.broker.addDeadLetter(consumer.topology.Queue, delivery.message)
+		return
+	}
+
+	delay := consumer.topology.RetryDelays[delivery.retryCount]
+	delivery.retryCount++
+
+	time.AfterFunc(delay, func() {
+		consumer.broker.queue(consumer.topology.Queue) <- delivery
+	})
+}
+
+// Stop stops the deliveries
+func (consumer *MemoryConsumer) Stop() error {
+	consumer.stopOnce.Do(func() {
+		close(consumer.stop)
+	})
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package queue
+
+// Message is a message published to a queue
+type Message struct {
+	ID   string
+	Body []byte
+}
+
+// Publisher publishes messages to the queues
+type Publisher interface {
+	// Publish sends the message through the exchange and returns once the broker accepted it
+	Publish(exchange string, routingKey string, message Message) error
+}
+
+// Handler handles a message, the message is retried if it returns an error
+type Handler func(message Message) error
+
+// Consumer delivers the messages of a queue
+type Consumer interface {
+	// Consume hands the messages to the handler one at a time until the consumer is stopped
+	Consume(handler Handler) error
+	// Stop stops the deliveries, Consume returns once the message being handled is done
+	Stop() error
+}
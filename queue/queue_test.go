@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryCount(t *testing.T) {
+	c := require.New(t)
+
+	c.Equal(0, RetryCount(nil))
+	c.Equal(0, RetryCount(amqp.Table{}))
+	c.Equal(2, RetryCount(amqp.Table{retryCountHeader: int32(2)}))
+	c.Equal(3, RetryCount(amqp.Table{retryCountHeader: int64(3)}))
+}
+
+func TestNextQueue(t *testing.T) {
+	c := require.New(t)
+
+	topology := Topology{Exchange: "orders", Queue: "assign-order", RetryDelays: []time.Duration{time.Second, time.Minute}}
+	err := errors.New("failed")
+
+	c.Equal("assign-order.retry.1", topology.nextQueue(0, err))
+	c.Equal("assign-order.retry.2", topology.nextQueue(1, err))
+	c.Equal("assign-order.dead", topology.nextQueue(2, err))
+	c.Equal("assign-order.dead", topology.nextQueue(0, Permanent(err)))
+}
+
+func TestIsPermanent(t *testing.T) {
+	c := require.New(t)
+
+	err := errors.New("failed")
+
+	c.False(IsPermanent(err))
+	c.True(IsPermanent(Permanent(err)))
+	c.True(errors.Is(Permanent(err), err))
+}
+
+func TestMemoryBroker(t *testing.T) {
+	c := require.New(t)
+
+	topology := Topology{Exchange: "orders", Queue: "assign-order", RetryDelays: []time.Duration{time.Millisecond, time.Millisecond}}
+	broker := NewMemoryBroker()
+	consumer := broker.NewConsumer(topology)
+
+	var publisher Publisher = broker
+	c.Nil(publisher.Publish(topology.Exchange, topology.RoutingKey(), Message{ID: "ok", Body: []byte("{}")}))
+	c.Nil(publisher.Publish(topology.Exchange, topology.RoutingKey(), Message{ID: "flaky"}))
+	c.Nil(publisher.Publish(topology.Exchange, topology.RoutingKey(), Message{ID: "failing"}))
+	c.Nil(publisher.Publish(topology.Exchange, topology.RoutingKey(), Message{ID: "malformed"}))
+
+	// the handler runs on the consumer goroutine only
+	attempts := map[string]int{}
+	handled := make(chan string, 16)
+	done := make(chan error)
+	go func() {
+		done <- consumer.Consume(func(message Message) error {
+			attempts[message.ID]++
+
+			switch message.ID {
+			case "flaky":
+				if attempts[message.ID] == 1 {
+					return errors.New("flaky")
+				}
+			case "failing":
+				return errors.New("failing")
+			case "malformed":
+				return Permanent(errors.New("malformed"))
+			}
+
+			handled <- message.ID
+			return nil
+		})
+	}()
+
+	c.Equal("ok", <-handled)
+	c.Equal("flaky", <-handled)
+	c.Eventually(func() bool {
+		return len(broker.DeadLetters(topology.Queue)) == 2
+	}, time.Second, time.Millisecond)
+
+	deadLetters := broker.DeadLetters(topology.Queue)
+	c.ElementsMatch([]string{"failing", "malformed"}, []string{deadLetters[0].ID, deadLetters[1].ID})
+
+	c.Nil(consumer.Stop())
+	c.Nil(<-done)
+}
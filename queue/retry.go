@@ -0,0 +1,91 @@
+package queue
+
+import (
+	"errors"
+	"log"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	retryCountHeader = "x-retry-count"
+	lastErrorHeader  = "x-last-error"
+)
+
+// permanentError is an error that retrying will not fix
+type permanentError struct {
+	err error
+}
+
+func (err permanentError) Error() string {
+	return err.err.Error()
+}
+
+func (err permanentError) Unwrap() error {
+	return err.err
+}
+
+// Permanent marks the error as one that retrying will not fix, the message goes straight to the dead letter queue
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// IsPermanent tells if the error was marked as permanent
+func IsPermanent(err error) bool {
+	return errors.As(err, &permanentError{})
+}
+
+// RetryCount returns how many times the message was already retried
+func RetryCount(headers amqp.Table) int {
+	switch count := headers[retryCountHeader].(type) {
+	case int32:
+		return int(count)
+	case int64:
+		return int(count)
+	case int:
+		return count
+	}
+
+	return 0
+}
+
+// nextQueue returns the queue a message that failed with the error goes to
+func (topology Topology) nextQueue(retryCount int, err error) string {
+	if IsPermanent(err) || retryCount >= len(topology.RetryDelays) {
+		return topology.DeadLetterQueue()
+	}
+
+	return topology.RetryQueue(retryCount + 1)
+}
+
+// Retry moves a message that failed to its next retry queue or to the dead letter queue. The message is only
+// acknowledged once the broker confirmed its copy, otherwise it is requeued so it is not lost
+func (topology Topology) Retry(publisher *AMQPPublisher, delivery amqp.Delivery, handlerErr error) error {
+	retryCount := RetryCount(delivery.Headers)
+
+	headers := amqp.Table{}
+	for key, value := range delivery.Headers {
+		headers[key] = value
+	}
+
+	headers[retryCountHeader] = int32(retryCount + 1)
+	headers[lastErrorHeader] = handlerErr.Error()
+
+	err := publisher.publish("", topology.nextQueue(retryCount, handlerErr), amqp.Publishing{
+		Headers:      headers,
+		ContentType:  delivery.ContentType,
+		DeliveryMode: amqp.Persistent,
+		MessageId:    delivery.MessageId,
+		Body:         delivery.Body,
+	})
+	if err != nil {
+		nackErr := delivery.Nack(false, true)
+		if nackErr != nil {
+			log.Println("error_requeueing_message: " + nackErr.Error())
+		}
+
+		return err
+	}
+
+	return delivery.Ack(false)
+}
@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Topology describes a work queue with delayed retries and a dead letter queue. Failed messages are
+// published to the retry queue of their attempt, which holds them for its delay and then dead letters
+// them back to the exchange. Messages that run out of retries end up in the dead letter queue
+type Topology struct {
+	Exchange    string
+	Queue       string
+	RetryDelays []time.Duration
+}
+
+// RoutingKey is the key the work queue is bound with
+func (topology Topology) RoutingKey() string {
+	return topology.Queue
+}
+
+// RetryQueue returns the name of the delay queue of the attempt, starting at 1
+func (topology Topology) RetryQueue(attempt int) string {
+	return topology.Queue + ".retry." + strconv.Itoa(attempt)
+}
+
+// DeadLetterQueue returns the name of the queue the messages go to once they run out of retries
+func (topology Topology) DeadLetterQueue() string {
+	return topology.Queue + ".dead"
+}
+
+// Declare creates the exchange and queues of the topology, it is safe to call it from the producer and the consumer
+func (topology Topology) Declare(channel *amqp.Channel) error {
+	err := channel.ExchangeDeclare(topology.Exchange, amqp.ExchangeDirect, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = channel.QueueDeclare(topology.Queue, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	err = channel.QueueBind(topology.Queue, topology.RoutingKey(), topology.Exchange, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for i, delay := range topology.RetryDelays {
+		_, err = channel.QueueDeclare(topology.RetryQueue(i+1), true, false, false, false, amqp.Table{
+			"x-message-ttl":             int64(delay / time.Millisecond),
+			"x-dead-letter-exchange":    topology.Exchange,
+			"x-dead-letter-routing-key": topology.RoutingKey(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = channel.QueueDeclare(topology.DeadLetterQueue(), true, false, false, false, nil)
+
+	return err
+}
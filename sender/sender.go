@@ -0,0 +1,169 @@
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSMSNotSupported the sender can not deliver sms
+	ErrSMSNotSupported = errors.New("sms not supported by sender")
+	// ErrNoSender no sender was configured
+	ErrNoSender = errors.New("no sender configured, set SENDER to smtp, file or log")
+	// ErrUnknownSender the sender is not one of the known ones
+	ErrUnknownSender = errors.New("unknown sender")
+)
+
+// Sender delivers messages to the email or phone number of a client
+type Sender interface {
+	SendEmail(to string, subject string, body string) error
+	SendSMS(to string, body string) error
+	// SupportsSMS tells if SendSMS can deliver messages
+	SupportsSMS() bool
+}
+
+// FromEnv returns the sender chosen with SENDER: smtp, file or log. The log sender writes the codes in plain
+// text so it is only used when chosen explicitly. Without SENDER, SMTP is used when SMTP_HOST is set and a file
+// when SENDER_FILE is set. SMTP senders deliver sms through twilio when TWILIO_ACCOUNT_SID is set
+func FromEnv() (Sender, error) {
+	kind := os.Getenv("SENDER")
+	if kind == "" && os.Getenv("SMTP_HOST") != "" {
+		kind = "smtp"
+	}
+
+	if kind == "" && os.Getenv("SENDER_FILE") != "" {
+		kind = "file"
+	}
+
+	switch kind {
+	case "smtp":
+		smtpSender := NewSMTPSender(os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
+		if accountSID := os.Getenv("TWILIO_ACCOUNT_SID"); accountSID != "" {
+			smtpSender.sms = NewTwilioSMSSender(accountSID, os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_FROM"))
+		}
+
+		return smtpSender, nil
+	case "file":
+		return NewFileSender(os.Getenv("SENDER_FILE")), nil
+	case "log":
+		return LogSender{}, nil
+	case "":
+		return nil, ErrNoSender
+	}
+
+	return nil, ErrUnknownSender
+}
+
+// LogSender writes the messages to the log, meant for local development
+type LogSender struct{}
+
+// SendEmail logs the email
+func (LogSender) SendEmail(to string, subject string, body string) error {
+	log.Printf("email to %s: %s - %s\n", to, subject, body)
+	return nil
+}
+
+// SendSMS logs the sms
+func (LogSender) SendSMS(to string, body string) error {
+	log.Printf("sms to %s: %s\n", to, body)
+	return nil
+}
+
+// SupportsSMS the sms are logged
+func (LogSender) SupportsSMS() bool {
+	return true
+}
+
+// FileSender appends the messages to a file, meant for local development
+type FileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileSender returns a sender writing to the file on the given path
+func NewFileSender(path string) *FileSender {
+	return &FileSender{path: path}
+}
+
+// SendEmail appends the email to the file
+func (s *FileSender) SendEmail(to string, subject string, body string) error {
+	return s.write(fmt.Sprintf("%s email to=%s subject=%q body=%q\n", time.Now().Format(time.RFC3339), to, subject, body))
+}
+
+// SendSMS appends the sms to the file
+func (s *FileSender) SendSMS(to string, body string) error {
+	return s.write(fmt.Sprintf("%s sms to=%s body=%q\n", time.Now().Format(time.RFC3339), to, body))
+}
+
+// SupportsSMS the sms are written to the file
+func (s *FileSender) SupportsSMS() bool {
+	return true
+}
+
+func (s *FileSender) write(line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.WriteString(line)
+	return err
+}
+
+// SMTPSender sends emails through an SMTP server and sms through the sms sender, if it has one
+type SMTPSender struct {
+	address string
+	auth    smtp.Auth
+	from    string
+	sms     *TwilioSMSSender
+}
+
+// NewSMTPSender returns a sender using the given SMTP server
+func NewSMTPSender(host string, port string, username string, password string, from string) *SMTPSender {
+	if port == "" {
+		port = "587"
+	}
+
+	return &SMTPSender{
+		address: host + ":" + port,
+		auth:    smtp.PlainAuth("", username, password, host),
+		from:    from,
+	}
+}
+
+// SendEmail sends the email
+func (s *SMTPSender) SendEmail(to string, subject string, body string) error {
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", s.from, to, subject, body)
+
+	err := smtp.SendMail(s.address, s.auth, s.from, []string{to}, []byte(message))
+	if err != nil {
+		log.Println("error_sending_email: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SendSMS sends the sms through the sms sender, sms are not supported over SMTP alone
+func (s *SMTPSender) SendSMS(to string, body string) error {
+	if s.sms == nil {
+		return ErrSMSNotSupported
+	}
+
+	return s.sms.SendSMS(to, body)
+}
+
+// SupportsSMS tells if the sender has an sms sender
+func (s *SMTPSender) SupportsSMS() bool {
+	return s.sms != nil
+}
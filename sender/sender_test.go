@@ -0,0 +1,86 @@
+package sender
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func setEnv(t *testing.T, values map[string]string) {
+	for key, value := range values {
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+
+		key := key
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, old)
+				return
+			}
+
+			os.Unsetenv(key)
+		})
+	}
+}
+
+func TestFromEnvRequiresASender(t *testing.T) {
+	c := require.New(t)
+	setEnv(t, map[string]string{"SENDER": "", "SMTP_HOST": "", "SENDER_FILE": ""})
+
+	_, err := FromEnv()
+	c.Equal(ErrNoSender, err)
+
+	setEnv(t, map[string]string{"SENDER": "logs"})
+	_, err = FromEnv()
+	c.Equal(ErrUnknownSender, err)
+
+	setEnv(t, map[string]string{"SENDER": "log"})
+	contactSender, err := FromEnv()
+	c.Nil(err)
+	c.Equal(LogSender{}, contactSender)
+}
+
+func TestFromEnvSMTPSupportsSMSWithTwilio(t *testing.T) {
+	c := require.New(t)
+	setEnv(t, map[string]string{"SENDER": "", "SMTP_HOST": "smtp.example.com", "TWILIO_ACCOUNT_SID": ""})
+
+	contactSender, err := FromEnv()
+	c.Nil(err)
+	c.False(contactSender.SupportsSMS())
+	c.Equal(ErrSMSNotSupported, contactSender.SendSMS("+521234567890", "code"))
+
+	setEnv(t, map[string]string{"TWILIO_ACCOUNT_SID": "AC123"})
+	contactSender, err = FromEnv()
+	c.Nil(err)
+	c.True(contactSender.SupportsSMS())
+}
+
+func TestTwilioSMSSender(t *testing.T) {
+	c := require.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		if r.URL.Path != "/Accounts/AC123/Messages.json" || username != "AC123" || password != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		c.Nil(r.ParseForm())
+		c.Equal("+521234567890", r.PostForm.Get("To"))
+		c.Equal("+15550000000", r.PostForm.Get("From"))
+		c.Equal("code 123456", r.PostForm.Get("Body"))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	smsSender := NewTwilioSMSSender("AC123", "token", "+15550000000")
+	smsSender.baseURL = server.URL
+	c.Nil(smsSender.SendSMS("+521234567890", "code 123456"))
+
+	smsSender = NewTwilioSMSSender("AC123", "wrong", "+15550000000")
+	smsSender.baseURL = server.URL
+	c.NotNil(smsSender.SendSMS("+521234567890", "code 123456"))
+}
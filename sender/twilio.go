@@ -0,0 +1,65 @@
+package sender
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// twilioAPIURL is the base url of the twilio REST API
+const twilioAPIURL = "https://api.twilio.com/2010-04-01"
+
+// TwilioSMSSender sends sms through the twilio REST API
+type TwilioSMSSender struct {
+	accountSID string
+	authToken  string
+	from       string
+	baseURL    string
+	client     *http.Client
+}
+
+// NewTwilioSMSSender returns a sender using the twilio account, from is the phone number the sms are sent from
+func NewTwilioSMSSender(accountSID string, authToken string, from string) *TwilioSMSSender {
+	return &TwilioSMSSender{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		baseURL:    twilioAPIURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SendSMS sends the sms
+func (s *TwilioSMSSender) SendSMS(to string, body string) error {
+	form := url.Values{}
+	form.Set("To", to)
+	form.Set("From", s.from)
+	form.Set("Body", body)
+
+	request, err := http.NewRequest(http.MethodPost, s.baseURL+"/Accounts/"+s.accountSID+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	request.SetBasicAuth(s.accountSID, s.authToken)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		log.Println("error_sending_sms: " + err.Error())
+		return err
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		err = fmt.Errorf("twilio responded with status %d", response.StatusCode)
+		log.Println("error_sending_sms: " + err.Error())
+		return err
+	}
+
+	return nil
+}
@@ -5,6 +5,20 @@ import (
 	"log"
 )
 
+// GetServiceByID returns a service given its id
+func GetServiceByID(db *sql.DB, serviceID int) (*Service, error) {
+	query := "SELECT service_id, display_name, service_category_id FROM service_table WHERE service_id = $1"
+
+	service := Service{}
+	err := db.QueryRow(query, serviceID).Scan(&service.ServiceID, &service.ServiceName, &service.ServiceCategoryID)
+	if err != nil {
+		log.Println("error_while_scanning_row_service_table: ", err.Error())
+		return nil, err
+	}
+
+	return &service, nil
+}
+
 func getServicesByCategoryID(db *sql.DB, categoryID int) ([]Service, error) {
 	query := "SELECT * FROM service_table WHERE service_category_id = $1"
 	rows, err := db.Query(query, categoryID)
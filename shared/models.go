@@ -2,7 +2,6 @@ package shared
 
 import (
 	"net/http"
-	"time"
 )
 
 // PatchOp represents a patch operation
@@ -13,10 +12,14 @@ const (
 	PatchOpReplace PatchOp = "replace"
 )
 
+// TokenClaims are the claims carried by an access token
 type TokenClaims struct {
 	ClientType ClientType `json:"type"`
 	ID         int        `json:"id"`
-	IAT        time.Time  `json:"iat"`
+	IAT        int64      `json:"iat"`
+	ExpiresAt  int64      `json:"exp"`
+	JTI        string     `json:"jti"`
+	MFAPending bool       `json:"mfa_pending,omitempty"`
 }
 
 // Client is the type of requester of a resource
@@ -34,6 +37,30 @@ var ClientTypeMechanic ClientType = "mechanic"
 // ClientTypeAdmin identifies admin
 var ClientTypeAdmin ClientType = "admin"
 
+// AnonymizedClient is a client whose personal data was wiped, with the email it had before
+type AnonymizedClient struct {
+	ClientID int
+	Email    string
+}
+
+// Locale is the language a client gets its messages in
+type Locale string
+
+const (
+	// LocaleSpanish spanish
+	LocaleSpanish Locale = "es"
+	// LocaleEnglish english
+	LocaleEnglish Locale = "en"
+)
+
+// DefaultLocale is the locale of the clients that did not choose one
+const DefaultLocale = LocaleSpanish
+
+// IsLocaleSupported tells if the messages are available in the locale
+func IsLocaleSupported(locale Locale) bool {
+	return locale == LocaleSpanish || locale == LocaleEnglish
+}
+
 // PatchRequestBody is the representation of the body of a PATCH request
 type PatchRequestBody []struct {
 	Op    PatchOp `json:"op"`
@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the secret used on the test vectors of RFC 6238
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	c := require.New(t)
+
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range vectors {
+		code, err := Code(rfcSecret, time.Unix(unix, 0))
+		c.Nil(err)
+		c.Equal(expected, code)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	c := require.New(t)
+
+	now := time.Unix(1111111111, 0)
+	code, err := Code(rfcSecret, now)
+	c.Nil(err)
+
+	step, ok := Verify(code, rfcSecret, now)
+	c.True(ok)
+	c.Equal(counter(now), step)
+
+	_, ok = Verify(code, rfcSecret, now.Add(Period*time.Second))
+	c.True(ok)
+
+	_, ok = Verify(code, rfcSecret, now.Add(3*Period*time.Second))
+	c.False(ok)
+
+	_, ok = Verify("12345", rfcSecret, now)
+	c.False(ok)
+}
+
+func TestURI(t *testing.T) {
+	c := require.New(t)
+
+	secret, err := GenerateSecret()
+	c.Nil(err)
+
+	uri := URI("Cartech", "mechanic@cartech.com", secret)
+	c.True(strings.HasPrefix(uri, "otpauth://totp/Cartech:mechanic@cartech.com?"))
+	c.Contains(uri, "secret="+secret)
+	c.Contains(uri, "issuer=Cartech")
+}
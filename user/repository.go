@@ -3,6 +3,7 @@ package user
 import (
 	"database/sql"
 	"log"
+	"time"
 
 	"github.com/CartechAPI/shared"
 	"github.com/lib/pq"
@@ -24,12 +25,13 @@ var uniqueConstraintsErrs = map[string]error{
 
 const uniqueViolationCode = "23505"
 
+const userColumns = "user_id, name, last_name, email, password, phone_number, verified_email, verified_phone, locale, deletion_requested_at"
+
 // GetUserByEmail searchs for an user by its email and returns it
 func GetUserByEmail(db *sql.DB, username string) (*User, error) {
-	user := User{}
-	query := "SELECT * FROM user_table WHERE email = $1;"
+	query := "SELECT " + userColumns + " FROM user_table WHERE email = $1;"
 
-	err := db.QueryRow(query, username).Scan(&user.UserID, &user.Name, &user.LastName, &user.Email, &user.Password, &user.PhoneNumber)
+	user, err := scanUser(db.QueryRow(query, username))
 	log.Println(err)
 	if err != nil && err != sql.ErrNoRows {
 		log.Println("failed_to_get_user: " + err.Error())
@@ -40,15 +42,14 @@ func GetUserByEmail(db *sql.DB, username string) (*User, error) {
 		return nil, nil
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // GetUserByID searchs for an user by its id and returns it
 func GetUserByID(db *sql.DB, id int) (*User, error) {
-	user := User{}
-	query := "SELECT * FROM user_table WHERE user_id = $1;"
+	query := "SELECT " + userColumns + " FROM user_table WHERE user_id = $1;"
 
-	err := db.QueryRow(query, id).Scan(&user.UserID, &user.Name, &user.LastName, &user.Email, &user.Password, &user.PhoneNumber)
+	user, err := scanUser(db.QueryRow(query, id))
 	if err != nil && err != sql.ErrNoRows {
 		log.Println("failed_to_get_user: " + err.Error())
 		return nil, err
@@ -58,6 +59,31 @@ func GetUserByID(db *sql.DB, id int) (*User, error) {
 		return nil, err
 	}
 
+	return user, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (*User, error) {
+	user := User{}
+	var locale sql.NullString
+	var deletionRequestedAt sql.NullTime
+	err := row.Scan(&user.UserID, &user.Name, &user.LastName, &user.Email, &user.Password, &user.PhoneNumber, &user.VerifiedEmail, &user.VerifiedPhone, &locale, &deletionRequestedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Locale = locale.String
+	if user.Locale == "" {
+		user.Locale = string(shared.DefaultLocale)
+	}
+
+	if deletionRequestedAt.Valid {
+		user.DeletionRequestedAt = &deletionRequestedAt.Time
+	}
+
 	return &user, nil
 }
 
@@ -66,18 +92,166 @@ func InsertUser(db *sql.DB, user *User) (*User, error) {
 	query := "INSERT INTO user_table (name, last_name, email, password, phone_number) VALUES($1, $2, $3, $4, $5) RETURNING user_id;"
 	err := db.QueryRow(query, user.Name, user.LastName, user.Email, user.Password, user.PhoneNumber).Scan(&user.UserID)
 	if err != nil {
-		pqError := err.(*pq.Error)
-		if pqError.Code == uniqueViolationCode {
-			if err, ok := uniqueConstraintsErrs[pqError.Constraint]; ok {
-				return nil, err
-			}
+		return nil, mapUniqueViolation(err)
+	}
+
+	log.Println(user)
+	return user, nil
+}
+
+// UpdateUserPassword replaces the password hash of the user
+func UpdateUserPassword(db *sql.DB, id int, hashedPassword string) error {
+	query := "UPDATE user_table SET password = $1 WHERE user_id = $2"
+
+	_, err := db.Exec(query, hashedPassword, id)
+	if err != nil {
+		log.Println("failed_to_update_user_password: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetUserEmailVerified marks the email of the user as verified
+func SetUserEmailVerified(db *sql.DB, id int) error {
+	query := "UPDATE user_table SET verified_email = TRUE WHERE user_id = $1"
+
+	_, err := db.Exec(query, id)
+	if err != nil {
+		log.Println("failed_to_verify_user_email: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// SetUserPhoneVerified marks the phone number of the user as verified
+func SetUserPhoneVerified(db *sql.DB, id int) error {
+	query := "UPDATE user_table SET verified_phone = TRUE WHERE user_id = $1"
+
+	_, err := db.Exec(query, id)
+	if err != nil {
+		log.Println("failed_to_verify_user_phone: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// GetAllUsers returns a page of the users ordered by id
+func GetAllUsers(db *sql.DB, limit int, offset int) ([]User, error) {
+	query := "SELECT " + userColumns + " FROM user_table ORDER BY user_id LIMIT $1 OFFSET $2"
+
+	rows, err := db.Query(query, limit, offset)
+	if err != nil {
+		log.Println("failed_to_get_users: " + err.Error())
+		return nil, err
+	}
 
-			return nil, ErrNotUniqueField
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			log.Println("failed_to_scan_user: " + err.Error())
+			return nil, err
 		}
 
+		users = append(users, *user)
+	}
+
+	return users, nil
+}
+
+// UpdateUser updates the profile fields of the user, the phone number stops being verified if it changes
+func UpdateUser(db *sql.DB, user User) error {
+	query := `UPDATE user_table SET name = $1, last_name = $2, verified_phone = (verified_phone AND phone_number = $3), phone_number = $3, locale = $4
+	WHERE user_id = $5`
+
+	_, err := db.Exec(query, user.Name, user.LastName, user.PhoneNumber, user.Locale, user.UserID)
+	if err != nil {
+		log.Println("failed_to_update_user: " + err.Error())
+		return mapUniqueViolation(err)
+	}
+
+	return nil
+}
+
+// mapUniqueViolation returns the public error of a unique constraint violation, other errors are returned as they are
+func mapUniqueViolation(err error) error {
+	pqError, ok := err.(*pq.Error)
+	if !ok || pqError.Code != uniqueViolationCode {
+		return err
+	}
+
+	if err, ok := uniqueConstraintsErrs[pqError.Constraint]; ok {
+		return err
+	}
+
+	return ErrNotUniqueField
+}
+
+// RequestUserDeletion schedules the anonymization of the user
+func RequestUserDeletion(db *sql.DB, id int) error {
+	query := "UPDATE user_table SET deletion_requested_at = NOW() WHERE user_id = $1 AND deleted_at IS NULL"
+
+	_, err := db.Exec(query, id)
+	if err != nil {
+		log.Println("failed_to_request_user_deletion: " + err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// CancelUserDeletion cancels a scheduled anonymization, it returns false if there was none
+func CancelUserDeletion(db *sql.DB, id int) (bool, error) {
+	query := "UPDATE user_table SET deletion_requested_at = NULL WHERE user_id = $1 AND deletion_requested_at IS NOT NULL AND deleted_at IS NULL"
+
+	result, err := db.Exec(query, id)
+	if err != nil {
+		log.Println("failed_to_cancel_user_deletion: " + err.Error())
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// AnonymizeDeletedUsers wipes the personal data of the users that requested their deletion before the given time.
+// The rows are kept so the service orders still reference them. It returns the anonymized users with the email
+// they had, so the rest of their data can be removed in the same transaction
+func AnonymizeDeletedUsers(tx *sql.Tx, requestedBefore time.Time) ([]shared.AnonymizedClient, error) {
+	query := `UPDATE user_table u SET
+		name = 'Deleted', last_name = 'User', email = 'deleted-' || u.user_id || '@deleted.cartech', phone_number = 'deleted-' || u.user_id,
+		password = '', verified_email = FALSE, verified_phone = FALSE, deleted_at = NOW()
+	FROM (SELECT user_id, email FROM user_table WHERE deletion_requested_at <= $1 AND deleted_at IS NULL FOR UPDATE) old
+	WHERE u.user_id = old.user_id
+	RETURNING u.user_id, old.email`
+
+	rows, err := tx.Query(query, requestedBefore)
+	if err != nil {
+		log.Println("failed_to_anonymize_users: " + err.Error())
 		return nil, err
 	}
 
-	log.Println(user)
-	return user, nil
+	defer rows.Close()
+
+	users := []shared.AnonymizedClient{}
+	for rows.Next() {
+		user := shared.AnonymizedClient{}
+		err = rows.Scan(&user.ClientID, &user.Email)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
 }
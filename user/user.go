@@ -1,13 +1,20 @@
 package user
 
+import "time"
+
 // User represents an user
 type User struct {
-	UserID      int    `json:"user_id"`
-	Name        string `json:"name"`
-	LastName    string `json:"last_name"`
-	Email       string `json:"email"`
-	Password    string `json:"password"`
-	PhoneNumber string `json:"phone_number"`
+	UserID        int    `json:"user_id"`
+	Name          string `json:"name"`
+	LastName      string `json:"last_name"`
+	Email         string `json:"email"`
+	Password      string `json:"password"`
+	PhoneNumber   string `json:"phone_number"`
+	VerifiedEmail bool   `json:"verified_email"`
+	VerifiedPhone bool   `json:"verified_phone"`
+	Locale        string `json:"locale"`
+
+	DeletionRequestedAt *time.Time `json:"deletion_requested_at,omitempty"`
 }
 
 func (user User) client() {}
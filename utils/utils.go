@@ -5,8 +5,8 @@ import (
 	"errors"
 	"log"
 	"net/http"
-	"os"
 
+	"github.com/CartechAPI/jwtkeys"
 	"github.com/CartechAPI/shared"
 
 	"github.com/dgrijalva/jwt-go"
@@ -15,6 +15,7 @@ import (
 var (
 	ErrInvalidTokenSigningMethod = errors.New("invalid token signing method")
 	ErrInvalidToken              = errors.New("invalid token")
+	ErrExpiredToken              = errors.New("expired token")
 	ErrCouldNoGetClaims          = errors.New("could not get claims")
 	ErrCouldNot                  = errors.New("could not")
 )
@@ -39,34 +40,53 @@ func RespondJSON(w http.ResponseWriter, statusCode int, data interface{}) {
 
 // DecodeToken decodes the token and returns the claims
 func DecodeToken(authToken string) (shared.ClientType, int, error) {
-	token, err := jwt.Parse(authToken, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, ErrInvalidTokenSigningMethod
-		}
+	tokenClaims, err := DecodeTokenClaims(authToken)
+	if err != nil {
+		return "", 0, err
+	}
 
-		return []byte(os.Getenv("SECRET")), nil
-	})
+	return tokenClaims.ClientType, tokenClaims.ID, nil
+}
+
+// DecodeTokenClaims decodes the token and returns all of its claims
+func DecodeTokenClaims(authToken string) (*shared.TokenClaims, error) {
+	keySet, err := jwtkeys.Default()
+	if err != nil {
+		log.Println("error_loading_jwt_keys: ", err.Error())
+		return nil, err
+	}
+
+	token, err := jwt.Parse(authToken, keySet.Keyfunc)
+
+	if validationErr, ok := err.(*jwt.ValidationError); ok && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return nil, ErrExpiredToken
+	}
 
 	if err != nil {
 		log.Println("error_parsing_jwt: ", err.Error())
-		return "", 0, err
+		return nil, err
 	}
 
 	if !token.Valid {
-		return "", 0, ErrInvalidToken
+		return nil, ErrInvalidToken
 	}
 
 	tokenClaims := shared.TokenClaims{}
 
 	claimsBytes, err := json.Marshal(token.Claims)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
 	err = json.Unmarshal(claimsBytes, &tokenClaims)
 	if err != nil {
-		return "", 0, err
+		return nil, err
 	}
 
-	return tokenClaims.ClientType, tokenClaims.ID, nil
+	// tokens without expiration are not accepted anymore
+	if tokenClaims.ExpiresAt == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	return &tokenClaims, nil
 }